@@ -0,0 +1,297 @@
+package dsio
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Conflict describes a change that wasn't applied because the entity in DataStore doesn't match
+// the state before the change.
+type Conflict struct {
+	Key    *datastore.Key
+	Reason string
+}
+
+// ApplyResult is the outcome of ApplyPatch.
+type ApplyResult struct {
+	Applied   int // changes written to DataStore
+	Unchanged int // changes already present in DataStore
+	Conflicts []Conflict
+}
+
+// ReadPatch reads a patch, calling fn for each change. A patch is either the changes written by diff in JSON format,
+// one per line, or a patch stream written by diff in .ds or NDJSON format (see PatchEntity).
+func ReadPatch(r io.Reader, fn func(c *Change) error) error {
+	meta, r, err := ReadMetadata(r)
+	if err != nil {
+		return err
+	}
+	if meta != nil {
+		if !meta.Patch {
+			return errors.New("Not a patch")
+		}
+		return readEntities(r, func(rec Entity) error {
+			c, err := patchChange(rec)
+			if err != nil {
+				return fmt.Errorf("%s: %v", MarshalKey(rec.Key), err)
+			}
+			return fn(c)
+		})
+	}
+	rbuf := bufio.NewScanner(r)
+	rbuf.Buffer(make([]byte, 32768), 1024*1024*1024)
+	linenr := 0
+	for rbuf.Scan() {
+		linenr++
+		if len(strings.TrimSpace(rbuf.Text())) == 0 {
+			continue
+		}
+		c := &Change{}
+		if err := json.Unmarshal(rbuf.Bytes(), c); err != nil {
+			return fmt.Errorf("line %d: %v", linenr, err)
+		}
+		switch c.Op {
+		case ChangeAdd, ChangeRemove, ChangeModify:
+		default:
+			return fmt.Errorf("line %d: Unsupported change %q", linenr, c.Op)
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return rbuf.Err()
+}
+
+// patchChange returns the change stored in an entity of a patch stream (see PatchEntity).
+func patchChange(rec Entity) (*Change, error) {
+	c := &Change{Key: rec.Key}
+	changes := make(map[string]*PropertyChange)
+	get := func(name string) *PropertyChange {
+		pc, ok := changes[name]
+		if !ok {
+			pc = &PropertyChange{Name: name}
+			changes[name] = pc
+		}
+		return pc
+	}
+	for _, p := range rec.Properties {
+		values := TypedValues{p.Value}
+		if arr, ok := p.Value.([]any); ok {
+			values = arr
+		}
+		if p.Name == patchOpProperty {
+			c.Op, _ = p.Value.(string)
+		} else if name, ok := strings.CutPrefix(p.Name, patchOldPrefix); ok {
+			pc := get(name)
+			pc.Old = append(pc.Old, values...)
+		} else {
+			pc := get(p.Name)
+			pc.New = append(pc.New, values...)
+			pc.NoIndex = p.NoIndex
+		}
+	}
+	switch c.Op {
+	case ChangeAdd, ChangeRemove, ChangeModify:
+	default:
+		return nil, fmt.Errorf("Unsupported change %q", c.Op)
+	}
+	for _, pc := range changes {
+		c.Properties = append(c.Properties, *pc)
+	}
+	slices.SortFunc(c.Properties, func(a, b PropertyChange) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return c, nil
+}
+
+// ApplyPatch applies a patch read from r to DataStore: added and modified entities are written
+// and removed entities are deleted. Each batch of changes is applied in a transaction.
+// A change is only applied if the entity in DataStore matches the state before the change
+// (optimistic concurrency), otherwise it's reported as a conflict and skipped.
+// Changes already present in DataStore are skipped, so a patch can be applied again.
+// If dryRun is true, the changes are checked but not applied.
+func ApplyPatch(ds *datastore.Client, r io.Reader, dryRun bool) (*ApplyResult, error) {
+	res := &ApplyResult{}
+	var batch []*Change
+	batchSize := 200
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var err error
+		if dryRun {
+			err = applyBatch(ds, nil, batch, res)
+		} else {
+			var tres *ApplyResult
+			_, err = ds.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+				tres = &ApplyResult{} // reset on retry
+				return applyBatch(ds, tx, batch, tres)
+			})
+			if err == nil {
+				res.Applied += tres.Applied
+				res.Unchanged += tres.Unchanged
+				res.Conflicts = append(res.Conflicts, tres.Conflicts...)
+			}
+		}
+		batch = nil
+		return err
+	}
+	err := ReadPatch(r, func(c *Change) error {
+		batch = append(batch, c)
+		if len(batch) >= batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	return res, err
+}
+
+// applyBatch applies the changes in the given transaction; if tx is nil, only checks the changes.
+func applyBatch(ds *datastore.Client, tx *datastore.Transaction, batch []*Change, res *ApplyResult) error {
+	keys := make([]*datastore.Key, len(batch))
+	for i, c := range batch {
+		keys[i] = c.Key
+	}
+	current := make([]datastore.PropertyList, len(keys))
+	var err error
+	if tx != nil {
+		err = tx.GetMulti(keys, current)
+	} else {
+		err = ds.GetMulti(context.Background(), keys, current)
+	}
+	var merr datastore.MultiError
+	if err != nil && !errors.As(err, &merr) {
+		return err
+	}
+	var putKeys, delKeys []*datastore.Key
+	var putRows []datastore.PropertyList
+	for i, c := range batch {
+		exists := true
+		if merr != nil && merr[i] != nil {
+			if merr[i] != datastore.ErrNoSuchEntity {
+				return merr[i]
+			}
+			exists = false
+		}
+		props, del, conflict := planChange(c, current[i], exists)
+		switch {
+		case conflict != "":
+			res.Conflicts = append(res.Conflicts, Conflict{Key: c.Key, Reason: conflict})
+		case del:
+			delKeys = append(delKeys, c.Key)
+		case props != nil:
+			putKeys = append(putKeys, c.Key)
+			putRows = append(putRows, props)
+		default:
+			res.Unchanged++
+		}
+	}
+	res.Applied += len(putKeys) + len(delKeys)
+	if tx == nil {
+		return nil
+	}
+	if len(putKeys) > 0 {
+		if _, err = tx.PutMulti(putKeys, putRows); err != nil {
+			return err
+		}
+	}
+	if len(delKeys) > 0 {
+		err = tx.DeleteMulti(delKeys)
+	}
+	return err
+}
+
+// planChange checks a change against the current entity.
+// Timestamps are compared at the precision of the export format, and the values are converted to the types
+// of the current values first, since array elements read from an export have no type (see exportedValue).
+// Returns the properties to write, or del if the entity is to be deleted, or the reason of a conflict.
+// Returns neither if the change is already present.
+func planChange(c *Change, current datastore.PropertyList, exists bool) (props datastore.PropertyList, del bool, conflict string) {
+	cur, _ := groupProperties(current)
+	changes := make([]PropertyChange, len(c.Properties))
+	for i, p := range c.Properties {
+		p.Old = exportedValues(p.Old, cur[p.Name])
+		p.New = exportedValues(p.New, cur[p.Name])
+		changes[i] = p
+	}
+	switch c.Op {
+	case ChangeAdd:
+		props = overlayProperties(nil, changes)
+		if !exists {
+			return props, false, ""
+		}
+		if len(diffPropertiesFunc(props, current, exportedEqual)) > 0 {
+			return nil, false, "Entity already exists"
+		}
+		return nil, false, ""
+	case ChangeRemove:
+		if !exists {
+			return nil, false, ""
+		}
+		if changed := diffPropertiesFunc(overlayProperties(nil, oldProperties(changes)), current, exportedEqual); len(changed) > 0 {
+			return nil, false, fmt.Sprintf("Property %q was modified", changed[0].Name)
+		}
+		return nil, true, ""
+	}
+	if !exists {
+		return nil, false, "Entity doesn't exist"
+	}
+	pending := false
+	for _, p := range changes {
+		switch {
+		case slices.EqualFunc(p.Old, cur[p.Name], exportedEqual):
+			pending = true
+		case !slices.EqualFunc(p.New, cur[p.Name], exportedEqual):
+			return nil, false, fmt.Sprintf("Property %q was modified", p.Name)
+		}
+	}
+	if !pending {
+		return nil, false, ""
+	}
+	return overlayProperties(current, changes), false, ""
+}
+
+// oldProperties returns the property changes reverted, i.e. with the old values as the new values.
+func oldProperties(changes []PropertyChange) []PropertyChange {
+	res := make([]PropertyChange, len(changes))
+	for i, p := range changes {
+		res[i] = PropertyChange{Name: p.Name, New: p.Old, NoIndex: p.NoIndex}
+	}
+	return res
+}
+
+// overlayProperties returns props with the properties in changes replaced by their new values.
+// Multiple values are written as an array.
+func overlayProperties(props datastore.PropertyList, changes []PropertyChange) datastore.PropertyList {
+	changed := make(map[string]bool)
+	for _, p := range changes {
+		changed[p.Name] = true
+	}
+	res := datastore.PropertyList{}
+	for _, p := range props {
+		if !changed[p.Name] {
+			res = append(res, p)
+		}
+	}
+	for _, p := range changes {
+		switch len(p.New) {
+		case 0:
+		case 1:
+			res = append(res, datastore.Property{Name: p.Name, Value: p.New[0], NoIndex: p.NoIndex})
+		default:
+			res = append(res, datastore.Property{Name: p.Name, Value: []any(p.New), NoIndex: p.NoIndex})
+		}
+	}
+	return res
+}
@@ -0,0 +1,127 @@
+package dsio
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadPatch(t *testing.T) {
+	patch := `{"key":"/Test,1","op":"modify","properties":[{"name":"A","old":["string:a"],"new":["string:b"],"noindex":true}]}
+
+{"key":"/Test,2","op":"remove","properties":[{"name":"N","old":["int:1"]}]}
+`
+	var changes []*Change
+	require.NoError(t, ReadPatch(strings.NewReader(patch), func(c *Change) error {
+		changes = append(changes, c)
+		return nil
+	}))
+	require.Len(t, changes, 2)
+	assert.Equal(t, &Change{Op: ChangeModify, Key: datastore.IDKey("Test", 1, nil),
+		Properties: []PropertyChange{{Name: "A", Old: TypedValues{"a"}, New: TypedValues{"b"}, NoIndex: true}}}, changes[0])
+	assert.Equal(t, TypedValues{int64(1)}, changes[1].Properties[0].Old)
+
+	err := ReadPatch(strings.NewReader(`{"key":"/Test,1","op":"upsert"}`), func(c *Change) error { return nil })
+	assert.EqualError(t, err, `line 1: Unsupported change "upsert"`)
+	err = ReadPatch(strings.NewReader(`{"key":"/Test,1","op":"add","properties":[{"name":"A","new":["a"]}]}`), func(c *Change) error { return nil })
+	assert.EqualError(t, err, `line 1: Missing type prefix in 'a'`)
+}
+
+func TestReadPatch_stream(t *testing.T) {
+	changes := []*Change{
+		{Op: ChangeModify, Key: datastore.IDKey("Test", 1, nil), Properties: []PropertyChange{
+			{Name: "A", Old: TypedValues{"a"}, New: TypedValues{"b"}, NoIndex: true},
+			{Name: "L", Old: TypedValues{int64(1), int64(2)}},
+		}},
+		{Op: ChangeAdd, Key: datastore.IDKey("Test", 2, nil), Properties: []PropertyChange{{Name: "B", New: TypedValues{"c"}}}},
+		{Op: ChangeRemove, Key: datastore.IDKey("Test", 3, nil), Properties: []PropertyChange{{Name: "N", Old: TypedValues{1.5}}}},
+	}
+	entities := make([]Entity, len(changes))
+	for i, c := range changes {
+		entities[i] = PatchEntity(c)
+	}
+	var res []*Change
+	require.NoError(t, ReadPatch(encodeEntities(t, &Metadata{Patch: true}, entities...), func(c *Change) error {
+		res = append(res, c)
+		return nil
+	}))
+	assert.Equal(t, changes, res)
+
+	err := ReadPatch(encodeEntities(t, &Metadata{}, entities...), func(c *Change) error { return nil })
+	assert.EqualError(t, err, "Not a patch")
+	bad := Entity{Key: datastore.IDKey("Test", 1, nil), Properties: datastore.PropertyList{{Name: "A", Value: "a"}}}
+	err = ReadPatch(encodeEntities(t, &Metadata{Patch: true}, bad), func(c *Change) error { return nil })
+	assert.EqualError(t, err, `/Test,1: Unsupported change ""`)
+}
+
+func TestPlanChange(t *testing.T) {
+	current := datastore.PropertyList{{Name: "A", Value: "a"}, {Name: "B", Value: int64(1)}}
+
+	add := &Change{Op: ChangeAdd, Properties: []PropertyChange{{Name: "A", New: TypedValues{"a"}}, {Name: "B", New: TypedValues{int64(1)}}}}
+	props, del, conflict := planChange(add, nil, false)
+	assert.Equal(t, current, props)
+	assert.False(t, del)
+	assert.Empty(t, conflict)
+	props, _, conflict = planChange(add, current, true)
+	assert.Nil(t, props)
+	assert.Empty(t, conflict)
+	_, _, conflict = planChange(add, current[:1], true)
+	assert.Equal(t, "Entity already exists", conflict)
+
+	remove := &Change{Op: ChangeRemove, Properties: []PropertyChange{{Name: "A", Old: TypedValues{"a"}}, {Name: "B", Old: TypedValues{int64(1)}}}}
+	_, del, conflict = planChange(remove, current, true)
+	assert.True(t, del)
+	assert.Empty(t, conflict)
+	props, del, conflict = planChange(remove, nil, false)
+	assert.Nil(t, props)
+	assert.False(t, del)
+	assert.Empty(t, conflict)
+	_, _, conflict = planChange(remove, datastore.PropertyList{{Name: "A", Value: "x"}, {Name: "B", Value: int64(1)}}, true)
+	assert.Equal(t, `Property "A" was modified`, conflict)
+
+	modify := &Change{Op: ChangeModify, Properties: []PropertyChange{
+		{Name: "A", Old: TypedValues{"a"}, New: TypedValues{"x"}},
+		{Name: "C", New: TypedValues{"c1", "c2"}, NoIndex: true},
+	}}
+	props, _, conflict = planChange(modify, current, true)
+	assert.Empty(t, conflict)
+	assert.Equal(t, datastore.PropertyList{
+		{Name: "B", Value: int64(1)},
+		{Name: "A", Value: "x"},
+		{Name: "C", Value: []any{"c1", "c2"}, NoIndex: true},
+	}, props)
+	props, _, conflict = planChange(modify, props, true)
+	assert.Nil(t, props)
+	assert.Empty(t, conflict)
+	_, _, conflict = planChange(modify, datastore.PropertyList{{Name: "A", Value: "y"}}, true)
+	assert.Equal(t, `Property "A" was modified`, conflict)
+	_, _, conflict = planChange(modify, nil, false)
+	assert.Equal(t, "Entity doesn't exist", conflict)
+
+	// exports have millisecond timestamps
+	at := time.Date(2024, 5, 1, 10, 30, 0, 123456789, time.UTC)
+	touch := &Change{Op: ChangeModify, Properties: []PropertyChange{{Name: "T", Old: TypedValues{at.Truncate(time.Millisecond)}, New: TypedValues{"x"}}}}
+	props, _, conflict = planChange(touch, datastore.PropertyList{{Name: "T", Value: at}}, true)
+	assert.Empty(t, conflict)
+	assert.Equal(t, datastore.PropertyList{{Name: "T", Value: "x"}}, props)
+
+	// array elements read from an export have no type, and are converted to the types of the current values
+	key := datastore.NameKey("User", "ann", nil)
+	arrays := &Change{Op: ChangeModify, Properties: []PropertyChange{
+		{Name: "K", Old: TypedValues{key.Encode()}, New: TypedValues{key.Encode(), key.Encode()}},
+		{Name: "T", Old: TypedValues{at.Format(time.RFC3339Nano), int64(1)}, New: TypedValues{at.Add(time.Hour).Format(time.RFC3339Nano)}},
+		{Name: "F", Old: TypedValues{int64(2)}, New: TypedValues{int64(3)}},
+	}}
+	current = datastore.PropertyList{{Name: "K", Value: []any{key}}, {Name: "T", Value: []any{at, int64(1)}}, {Name: "F", Value: []any{2.0}}}
+	props, _, conflict = planChange(arrays, current, true)
+	assert.Empty(t, conflict)
+	assert.Equal(t, datastore.PropertyList{
+		{Name: "K", Value: []any{key, key}},
+		{Name: "T", Value: at.Add(time.Hour)},
+		{Name: "F", Value: 3.0},
+	}, props)
+}
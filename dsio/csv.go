@@ -0,0 +1,350 @@
+package dsio
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+)
+
+// CSVOptions controls the CSV format.
+type CSVOptions struct {
+	// Comma is the field delimiter, ',' if zero; '\t' for TSV.
+	Comma rune
+	// Kind is the kind of the keys given as a plain ID or name when reading, and of the entities without a key.
+	Kind string
+}
+
+// csvKeyColumn is the name of the key column, whose values are in dsutil notation.
+const csvKeyColumn = "__key__"
+
+var csvTypes = []string{"string", "int", "float", "double", "bool", "time", "key", "blob", "any"}
+
+type csvColumn struct {
+	name     string
+	typ      string // a type prefix of ParseTypedValue, or "any" for typed literals
+	repeated bool   // values are written as a JSON array
+	noIndex  bool
+}
+
+// header returns the column header, e.g. "tags:string[]:noindex".
+func (c *csvColumn) header() string {
+	h := c.name + ":" + c.typ
+	if c.repeated {
+		h += "[]"
+	}
+	if c.noIndex {
+		h += ":noindex"
+	}
+	return h
+}
+
+// parseCSVHeader parses a column header; columns without a type declaration are strings.
+func parseCSVHeader(h string) *csvColumn {
+	c := &csvColumn{name: h, typ: "string"}
+	if name, ok := strings.CutSuffix(h, ":noindex"); ok {
+		c.name, c.noIndex = name, true
+	}
+	if i := strings.LastIndexByte(c.name, ':'); i >= 0 {
+		typ, repeated := strings.CutSuffix(strings.ToLower(c.name[i+1:]), "[]")
+		if slices.Contains(csvTypes, typ) {
+			c.name, c.typ, c.repeated = c.name[:i], typ, repeated
+		}
+	}
+	return c
+}
+
+// WriteCSV writes the entities of the export stream r as CSV, with a "__key__" column
+// and a column for each property of the given kinds (as inferred by ReadSchema).
+// The header declares the column types, e.g. "age:int", "tags:string[]" or "body:string:noindex".
+// Values of mixed-type properties are written as typed literals (column type "any")
+// and the values of repeated properties as a JSON array.
+// Missing and null properties are written as empty cells. An empty string is written as `""` in string columns
+// (and a string of only double quotes gets two more) and as "string:" in "any" columns; JSON arrays need no marker.
+func WriteCSV(w io.Writer, kinds []*KindSchema, r io.Reader, opts CSVOptions) error {
+	columns := csvColumns(kinds)
+	cw := csv.NewWriter(w)
+	if opts.Comma != 0 {
+		cw.Comma = opts.Comma
+	}
+	record := []string{csvKeyColumn}
+	for _, c := range columns {
+		record = append(record, c.header())
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+	err := readEntities(r, func(rec Entity) error {
+		values, _ := groupProperties(rec.Properties)
+		record = append(record[:0], MarshalKey(rec.Key))
+		for _, c := range columns {
+			s, err := c.format(values[c.name])
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", MarshalKey(rec.Key), c.name, err)
+			}
+			record = append(record, s)
+		}
+		return cw.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvColumns returns the columns of the properties of all kinds, sorted by name.
+func csvColumns(kinds []*KindSchema) []*csvColumn {
+	columns := make(map[string]*csvColumn)
+	types := make(map[string]map[string]bool)
+	for _, ks := range kinds {
+		for _, p := range ks.Properties {
+			c := columns[p.Name]
+			if c == nil {
+				c = &csvColumn{name: p.Name}
+				columns[p.Name] = c
+				types[p.Name] = make(map[string]bool)
+			}
+			c.repeated = c.repeated || p.Repeated
+			c.noIndex = c.noIndex || p.NoIndex
+			for _, t := range p.Types {
+				if t != "null" {
+					types[p.Name][t] = true
+				}
+			}
+		}
+	}
+	var res []*csvColumn
+	for _, name := range slices.Sorted(maps.Keys(columns)) {
+		c := columns[name]
+		switch len(types[name]) {
+		case 0:
+			c.typ = "string"
+		case 1:
+			for t := range types[name] {
+				c.typ = t
+			}
+		default:
+			c.typ = "any"
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// format returns the cell of the given values.
+func (c *csvColumn) format(values []any) (string, error) {
+	if len(values) == 0 {
+		return "", nil
+	}
+	if !c.repeated {
+		return c.formatValue(values[0])
+	}
+	arr := make([]any, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case nil:
+		case int64:
+			if c.typ != "any" {
+				arr[i] = v
+				continue
+			}
+		case float64:
+			if c.typ != "any" && !math.IsNaN(v) && !math.IsInf(v, 0) {
+				arr[i] = v
+				continue
+			}
+		case bool:
+			if c.typ != "any" {
+				arr[i] = v
+				continue
+			}
+		}
+		if v != nil {
+			s, err := c.formatValue(v)
+			if err != nil {
+				return "", err
+			}
+			arr[i] = s
+		}
+	}
+	b, err := json.Marshal(arr)
+	return string(b), err
+}
+
+// formatValue returns a scalar value as a literal of the column type.
+func (c *csvColumn) formatValue(value any) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	s, err := FormatTypedValue(value)
+	if err != nil || c.typ == "any" {
+		return s, err
+	}
+	_, s, _ = strings.Cut(s, ":")
+	if c.typ == "string" && !c.repeated && strings.Trim(s, `"`) == "" && len(s) != 1 { // JSON arrays need no marker
+		s = `""` + s
+	}
+	return s, nil
+}
+
+// parse returns the value of a non-empty cell.
+func (c *csvColumn) parse(s string) (any, error) {
+	if !c.repeated {
+		return c.parseValue(s)
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var arr []any
+	if err := dec.Decode(&arr); err != nil {
+		return nil, fmt.Errorf("Unable to parse '%s' as a JSON array", s)
+	}
+	for i, e := range arr {
+		var err error
+		switch e := e.(type) {
+		case string:
+			arr[i], err = c.parseValue(e)
+		case json.Number:
+			if c.typ != "any" {
+				arr[i], err = c.parseValue(e.String())
+			} else if arr[i], err = e.Int64(); err != nil {
+				arr[i], err = e.Float64()
+			}
+		case bool:
+			if c.typ != "any" && c.typ != "bool" {
+				arr[i], err = c.parseValue(strconv.FormatBool(e))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return arr, nil
+}
+
+func (c *csvColumn) parseValue(s string) (any, error) {
+	if c.typ == "any" {
+		return ParseTypedValue(s, nil)
+	}
+	if c.typ == "string" && !c.repeated && strings.Trim(s, `"`) == "" && len(s) >= 2 {
+		return s[2:], nil // an empty string, see formatValue
+	}
+	return parseValueAs(c.typ, s)
+}
+
+// ReadCSV reads CSV entities as written by WriteCSV, calling fn for each entity.
+// Column types are declared in the header, e.g. "age:int", and default to string.
+// The "__key__" column holds keys in any notation supported by ParseAnyKey, or plain IDs or names of opts.Kind;
+// entities without a key get an incomplete key of opts.Kind. Empty cells are skipped.
+func ReadCSV(r io.Reader, opts CSVOptions, fn func(Entity) error) error {
+	return readCSV(r, opts, false, fn)
+}
+
+// readCSV implements ReadCSV; with requireKeys, rows without a key are an error.
+func readCSV(r io.Reader, opts CSVOptions, requireKeys bool, fn func(Entity) error) error {
+	cr := csv.NewReader(r)
+	if opts.Comma != 0 {
+		cr.Comma = opts.Comma
+	}
+	cr.ReuseRecord = true
+	cr.LazyQuotes = cr.Comma == '\t' // TSV files often have unquoted JSON arrays
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keyColumn := -1
+	columns := make([]*csvColumn, len(header))
+	for i, h := range header {
+		if i == 0 {
+			h = strings.TrimPrefix(h, "\ufeff") // byte order mark written by spreadsheets
+		}
+		if h == csvKeyColumn {
+			keyColumn = i
+		} else {
+			columns[i] = parseCSVHeader(h)
+		}
+	}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		rec := Entity{Properties: datastore.PropertyList{}}
+		for i, s := range record {
+			if i == keyColumn {
+				if rec.Key, err = parseCSVKey(s, opts.Kind); err != nil {
+					return fmt.Errorf("line %d: %v", line, err)
+				}
+				continue
+			}
+			if s == "" {
+				continue
+			}
+			c := columns[i]
+			value, err := c.parse(s)
+			if err != nil {
+				return fmt.Errorf("line %d: column %s: %v", line, c.name, err)
+			}
+			rec.Properties = append(rec.Properties, datastore.Property{Name: c.name, Value: value, NoIndex: c.noIndex})
+		}
+		if rec.Key == nil {
+			if rec.Key, err = parseCSVKey("", opts.Kind); err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+		if requireKeys && rec.Key.Incomplete() {
+			return fmt.Errorf("line %d: Missing key, required in merge mode", line)
+		}
+		if err = fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// parseCSVKey parses a key cell: a key in any notation, or an ID or name of the given kind.
+func parseCSVKey(s, kind string) (*datastore.Key, error) {
+	switch {
+	case s == "" && kind == "":
+		return nil, errors.New("Missing key and kind")
+	case s == "":
+		return datastore.IncompleteKey(kind, nil), nil
+	case kind == "" || strings.HasPrefix(s, "/") || strings.HasPrefix(strings.ToUpper(s), "KEY("):
+		return ParseAnyKey(s)
+	}
+	if id, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return datastore.IDKey(kind, id, nil), nil
+	}
+	return datastore.NameKey(kind, s, nil), nil
+}
+
+func csvReader(r io.Reader, opts CSVOptions, requireKeys bool) entityReader {
+	return func(fn func(Entity) error) error {
+		return readCSV(r, opts, requireKeys, fn)
+	}
+}
+
+// ImportCSV imports DataStore entities from a CSV file (see ReadCSV).
+func ImportCSV(r io.Reader, ds *datastore.Client, opts CSVOptions) error {
+	return putEntities(csvReader(r, opts, false), ds)
+}
+
+// ImportCSVMerge imports DataStore entities from a CSV file (see ReadCSV), merging them into the existing entities
+// like ImportMerge. Useful for files with only some of the columns. Every row must have a key.
+func ImportCSVMerge(r io.Reader, ds *datastore.Client, opts CSVOptions, deleteFields []string) error {
+	return mergeEntities(csvReader(r, opts, true), ds, deleteFields)
+}
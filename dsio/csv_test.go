@@ -0,0 +1,128 @@
+package dsio
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCSV(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	entities := []Entity{
+		{Key: datastore.IDKey("Event", 1, nil), Properties: datastore.PropertyList{
+			{Name: "title", Value: "a, \"b\""},
+			{Name: "at", Value: ts},
+			{Name: "tags", Value: []any{"x", "y"}},
+			{Name: "scores", Value: []any{int64(1), int64(2)}},
+			{Name: "v", Value: int64(3)},
+			{Name: "body", Value: "text", NoIndex: true},
+		}},
+		{Key: datastore.NameKey("Note", "e2", nil), Properties: datastore.PropertyList{
+			{Name: "title", Value: "e2"},
+		}},
+	}
+	kinds, err := ReadSchema(encodeEntities(t, nil, entities...))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, WriteCSV(&buf, kinds, encodeEntities(t, nil, entities...), CSVOptions{}))
+	assert.Equal(t, `__key__,at:time,body:string:noindex,scores:int[],tags:string[],title:string,v:int
+"/Event,1",2024-05-01T10:30:00Z,text,"[1,2]","[""x"",""y""]","a, ""b""",3
+"/Note,e2",,,,,e2,
+`, buf.String())
+
+	var res []Entity
+	require.NoError(t, ReadCSV(&buf, CSVOptions{}, func(rec Entity) error {
+		res = append(res, rec)
+		return nil
+	}))
+	require.Len(t, res, 2)
+	assert.Equal(t, entities[0].Key, res[0].Key)
+	assert.Equal(t, datastore.PropertyList{
+		{Name: "at", Value: ts},
+		{Name: "body", Value: "text", NoIndex: true},
+		{Name: "scores", Value: []any{int64(1), int64(2)}},
+		{Name: "tags", Value: []any{"x", "y"}},
+		{Name: "title", Value: "a, \"b\""},
+		{Name: "v", Value: int64(3)},
+	}, res[0].Properties)
+	assert.Equal(t, datastore.PropertyList{{Name: "title", Value: "e2"}}, res[1].Properties)
+}
+
+func TestReadCSV(t *testing.T) {
+	in := "\ufeffname\t__key__\tage:int\tactive:bool\tx:any[]\n" +
+		"Ann\t12\t30\ttrue\t[\"int:1\", \"string:a\", 2.5]\n" +
+		"Bob\tbob\t\tfalse\t\n" +
+		"Eve\t\t25\t\t\n" +
+		"Joe\tKEY(Other, 'x')\t40\t\t\n"
+	var res []Entity
+	require.NoError(t, ReadCSV(strings.NewReader(in), CSVOptions{Comma: '\t', Kind: "Person"}, func(rec Entity) error {
+		res = append(res, rec)
+		return nil
+	}))
+	require.Len(t, res, 4)
+	assert.Equal(t, datastore.IDKey("Person", 12, nil), res[0].Key)
+	assert.Equal(t, datastore.PropertyList{{Name: "name", Value: "Ann"}, {Name: "age", Value: int64(30)}, {Name: "active", Value: true},
+		{Name: "x", Value: []any{int64(1), "a", 2.5}}}, res[0].Properties)
+	assert.Equal(t, datastore.NameKey("Person", "bob", nil), res[1].Key)
+	assert.Equal(t, datastore.PropertyList{{Name: "name", Value: "Bob"}, {Name: "active", Value: false}}, res[1].Properties)
+	assert.Equal(t, datastore.IncompleteKey("Person", nil), res[2].Key)
+	assert.Equal(t, datastore.NameKey("Other", "x", nil), res[3].Key)
+
+	err := ReadCSV(strings.NewReader("__key__,age:int\n/Person,1,x\n"), CSVOptions{}, func(Entity) error { return nil })
+	assert.Error(t, err)
+	err = ReadCSV(strings.NewReader("__key__,age:int\n\"/Person,1\",x\n"), CSVOptions{}, func(Entity) error { return nil })
+	assert.EqualError(t, err, "line 2: column age: Unable to parse 'x' as int")
+}
+
+func TestCSV_emptyStrings(t *testing.T) {
+	entities := []Entity{
+		{Key: datastore.IDKey("A", 1, nil), Properties: datastore.PropertyList{{Name: "s", Value: ""}, {Name: "tags", Value: []any{"", "x", `""`}}, {Name: "x", Value: ""}}},
+		{Key: datastore.IDKey("A", 2, nil), Properties: datastore.PropertyList{{Name: "s", Value: `"`}, {Name: "x", Value: int64(1)}}},
+		{Key: datastore.IDKey("A", 3, nil), Properties: datastore.PropertyList{{Name: "s", Value: `""`}}},
+		{Key: datastore.IDKey("A", 4, nil), Properties: datastore.PropertyList{}},
+	}
+	var in bytes.Buffer // NDJSON, since .ds files have a single type per property
+	for _, rec := range entities {
+		b, err := MarshalNDJSON(rec)
+		require.NoError(t, err)
+		in.Write(append(b, '\n'))
+	}
+	kinds, err := ReadSchema(bytes.NewReader(in.Bytes()))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, WriteCSV(&buf, kinds, &in, CSVOptions{}))
+	assert.Equal(t, `__key__,s:string,tags:string[],x:any
+"/A,1","""""","["""",""x"",""\""\""""]",string:
+"/A,2","""",,int:1
+"/A,3","""""""""",,
+"/A,4",,,
+`, buf.String())
+
+	var res []Entity
+	require.NoError(t, ReadCSV(&buf, CSVOptions{}, func(rec Entity) error {
+		res = append(res, rec)
+		return nil
+	}))
+	require.Len(t, res, 4)
+	for i := range entities {
+		assert.Equal(t, entities[i].Properties, res[i].Properties)
+	}
+}
+
+func TestReadCSV_requireKeys(t *testing.T) {
+	in := "__key__,name\n1,a\n,b\n"
+	n := 0
+	err := readCSV(strings.NewReader(in), CSVOptions{Kind: "A"}, true, func(Entity) error {
+		n++
+		return nil
+	})
+	assert.EqualError(t, err, "line 3: Missing key, required in merge mode")
+	assert.Equal(t, 1, n)
+	err = readCSV(strings.NewReader("name\na\n"), CSVOptions{Kind: "A"}, true, func(Entity) error { return nil })
+	assert.EqualError(t, err, "line 2: Missing key, required in merge mode")
+}
@@ -0,0 +1,349 @@
+package dsio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Change operations.
+const (
+	ChangeAdd    = "add"
+	ChangeRemove = "remove"
+	ChangeModify = "modify"
+)
+
+// Change describes a difference of a single entity between two exports.
+type Change struct {
+	Op  string         `json:"op"`
+	Key *datastore.Key `json:"-"`
+	// Properties lists all properties of added and removed entities, and the changed properties of modified entities.
+	Properties []PropertyChange `json:"properties,omitempty"`
+	// Before holds all properties of removed and modified entities before the change.
+	Before datastore.PropertyList `json:"-"`
+	// After holds all properties of added and modified entities after the change.
+	After datastore.PropertyList `json:"-"`
+}
+
+// PropertyChange describes the values of a property before and after a change.
+// Values are serialized as literals with a type prefix (see FormatTypedValue); missing properties have no values.
+type PropertyChange struct {
+	Name    string      `json:"name"`
+	Old     TypedValues `json:"old,omitempty"`
+	New     TypedValues `json:"new,omitempty"`
+	NoIndex bool        `json:"noindex,omitempty"`
+}
+
+// TypedValues is a list of property values, serialized as literals with a type prefix.
+type TypedValues []any
+
+// MarshalJSON implements json.Marshaler.
+func (tv TypedValues) MarshalJSON() ([]byte, error) {
+	res := make([]*string, len(tv))
+	for i, v := range tv {
+		if v == nil {
+			continue
+		}
+		s, err := FormatTypedValue(v)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = &s
+	}
+	return json.Marshal(res)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (tv *TypedValues) UnmarshalJSON(b []byte) error {
+	var literals []*string
+	if err := json.Unmarshal(b, &literals); err != nil {
+		return err
+	}
+	*tv = make(TypedValues, len(literals))
+	for i, s := range literals {
+		if s == nil {
+			continue
+		}
+		if !HasTypePrefix(*s) {
+			return fmt.Errorf("Missing type prefix in '%s'", *s)
+		}
+		v, err := ParseTypedValue(*s, nil)
+		if err != nil {
+			return err
+		}
+		(*tv)[i] = v
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, writing the key in the MarshalKey notation.
+func (c Change) MarshalJSON() ([]byte, error) {
+	type change Change // prevent recursion
+	return json.Marshal(struct {
+		Key string `json:"key"`
+		change
+	}{MarshalKey(c.Key), change(c)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *Change) UnmarshalJSON(b []byte) error {
+	type change Change // prevent recursion
+	var jc struct {
+		Key string `json:"key"`
+		change
+	}
+	if err := json.Unmarshal(b, &jc); err != nil {
+		return err
+	}
+	key, err := ParseKey(jc.Key)
+	if err != nil {
+		return err
+	}
+	*c = Change(jc.change)
+	c.Key = key
+	return nil
+}
+
+// Diff compares two export streams, calling fn for each changed entity:
+// first the added and modified entities in the order of b, then the removed entities in the order of a.
+// The entities of a are kept in a temporary file, with only their keys in memory.
+func Diff(a, b io.Reader, fn func(c *Change) error) error {
+	_, a, err := ReadMetadata(a)
+	if err != nil {
+		return err
+	}
+	_, b, err = ReadMetadata(b)
+	if err != nil {
+		return err
+	}
+	old, err := newEntitySpill()
+	if err != nil {
+		return err
+	}
+	defer old.close()
+	if err = readEntities(a, old.add); err != nil {
+		return err
+	}
+	err = readEntities(b, func(rec Entity) error {
+		prev, ok, err := old.take(MarshalKey(rec.Key))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fn(&Change{Op: ChangeAdd, Key: rec.Key, Properties: diffProperties(nil, rec.Properties), After: rec.Properties})
+		}
+		if props := diffProperties(prev.Properties, rec.Properties); len(props) > 0 {
+			return fn(&Change{Op: ChangeModify, Key: rec.Key, Properties: props, Before: prev.Properties, After: rec.Properties})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return old.rest(func(rec Entity) error {
+		return fn(&Change{Op: ChangeRemove, Key: rec.Key, Properties: diffProperties(rec.Properties, nil), Before: rec.Properties})
+	})
+}
+
+// Names of the special properties of patch entities.
+const (
+	patchOpProperty = "__op__"
+	patchOldPrefix  = "__old__."
+)
+
+// PatchEntity returns a change as an entity of a patch stream, written by diff in .ds or NDJSON format.
+// Besides the "__op__" property holding the change operation, the entity has the new values of the changed properties
+// under their names and the old values under "__old__." + name. Values keep their form in Before and After if set,
+// otherwise a single value is stored as is and other values as an array.
+// Patch streams are marked by Metadata.Patch, and can be read by ReadPatch.
+func PatchEntity(c *Change) Entity {
+	rec := Entity{Key: c.Key, Properties: datastore.PropertyList{{Name: patchOpProperty, Value: c.Op, NoIndex: true}}}
+	for _, p := range c.Properties {
+		if len(p.New) > 0 {
+			rec.Properties = append(rec.Properties, datastore.Property{Name: p.Name, Value: patchValue(p.Name, c.After, p.New), NoIndex: p.NoIndex})
+		}
+		if len(p.Old) > 0 {
+			rec.Properties = append(rec.Properties, datastore.Property{Name: patchOldPrefix + p.Name, Value: patchValue(p.Name, c.Before, p.Old), NoIndex: true})
+		}
+	}
+	return rec
+}
+
+func patchValue(name string, props datastore.PropertyList, values TypedValues) any {
+	var found []any
+	for _, p := range props {
+		if p.Name == name {
+			found = append(found, p.Value)
+		}
+	}
+	if len(found) == 1 {
+		return found[0]
+	}
+	if len(values) == 1 && values[0] != nil {
+		return values[0]
+	}
+	return []any(values)
+}
+
+// groupProperties returns the values of each property by name, and the property names in order of appearance.
+// Array values are flattened.
+func groupProperties(props datastore.PropertyList) (map[string][]any, []string) {
+	values := make(map[string][]any)
+	var names []string
+	for _, p := range props {
+		if _, ok := values[p.Name]; !ok {
+			names = append(names, p.Name)
+		}
+		if arr, ok := p.Value.([]any); ok {
+			values[p.Name] = append(values[p.Name], arr...)
+		} else {
+			values[p.Name] = append(values[p.Name], p.Value)
+		}
+	}
+	return values, names
+}
+
+// noIndexProperties returns the names of the unindexed properties.
+func noIndexProperties(props datastore.PropertyList) map[string]bool {
+	res := make(map[string]bool)
+	for _, p := range props {
+		if p.NoIndex {
+			res[p.Name] = true
+		}
+	}
+	return res
+}
+
+// diffProperties returns the properties having different values, sorted by name.
+func diffProperties(a, b datastore.PropertyList) []PropertyChange {
+	return diffPropertiesFunc(a, b, valueEqual)
+}
+
+// diffPropertiesFunc is like diffProperties, comparing values with eq.
+func diffPropertiesFunc(a, b datastore.PropertyList, eq func(a, b any) bool) []PropertyChange {
+	av, anames := groupProperties(a)
+	bv, bnames := groupProperties(b)
+	noIndex := noIndexProperties(b)
+	if b == nil {
+		noIndex = noIndexProperties(a)
+	}
+	names := append(anames, bnames...)
+	slices.Sort(names)
+	names = slices.Compact(names)
+	var res []PropertyChange
+	for _, name := range names {
+		if !slices.EqualFunc(av[name], bv[name], eq) {
+			res = append(res, PropertyChange{Name: name, Old: av[name], New: bv[name], NoIndex: noIndex[name]})
+		}
+	}
+	return res
+}
+
+func valueEqual(a, b any) bool {
+	switch a := a.(type) {
+	case time.Time:
+		b, ok := b.(time.Time)
+		return ok && a.Equal(b)
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	case *datastore.Key:
+		b, ok := b.(*datastore.Key)
+		return ok && (a == nil) == (b == nil) && (a == nil || a.Equal(b))
+	}
+	return a == b
+}
+
+// exportedEqual reports whether a value read from an export equals a DataStore value,
+// comparing timestamps at the millisecond precision of the export format.
+func exportedEqual(a, b any) bool {
+	if a, ok := a.(time.Time); ok {
+		b, ok := b.(time.Time)
+		return ok && a.Truncate(time.Millisecond).Equal(b.Truncate(time.Millisecond))
+	}
+	return valueEqual(a, b)
+}
+
+// exportedValue converts an array element read from an export to the type of the DataStore value like, if possible.
+// Array elements have no type in the export format, so timestamps, keys and blobs are read back as strings
+// and whole floats as int64.
+func exportedValue(v, like any) any {
+	switch like.(type) {
+	case time.Time:
+		if s, ok := v.(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				return t
+			}
+		}
+	case *datastore.Key:
+		if s, ok := v.(string); ok {
+			if k, err := datastore.DecodeKey(s); err == nil {
+				return k
+			}
+		}
+	case []byte:
+		if s, ok := v.(string); ok {
+			if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+				return b
+			}
+		}
+	case float64:
+		if n, ok := v.(int64); ok {
+			return float64(n)
+		}
+	}
+	return v
+}
+
+// exportedValues converts values read from an export to the types of the DataStore values like (see exportedValue),
+// by position; values past the end of like are converted to the type of its last value.
+func exportedValues(values, like []any) []any {
+	if len(like) == 0 || len(values) == 0 {
+		return values
+	}
+	res := make([]any, len(values))
+	for i, v := range values {
+		res[i] = exportedValue(v, like[min(i, len(like)-1)])
+	}
+	return res
+}
+
+// FormatChange returns a human-readable description of a change, e.g.
+//
+//	~ /Kind,1
+//	    Name: 'old' -> 'new'
+func FormatChange(c *Change) string {
+	var sb bytes.Buffer
+	switch c.Op {
+	case ChangeAdd:
+		sb.WriteString("+ ")
+	case ChangeRemove:
+		sb.WriteString("- ")
+	default:
+		sb.WriteString("~ ")
+	}
+	sb.WriteString(MarshalKey(c.Key) + "\n")
+	if c.Op != ChangeModify {
+		return sb.String()
+	}
+	for _, p := range c.Properties {
+		fmt.Fprintf(&sb, "    %s: %s -> %s\n", p.Name, formatValues(p.Old), formatValues(p.New))
+	}
+	return sb.String()
+}
+
+func formatValues(values []any) string {
+	switch len(values) {
+	case 0:
+		return "(missing)"
+	case 1:
+		return formatGQLValue(values[0])
+	}
+	return formatGQLValue(values)
+}
@@ -0,0 +1,71 @@
+package dsio
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	dt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	key := func(id int64) *datastore.Key { return datastore.IDKey("Test", id, nil) }
+	a := encodeEntities(t, nil,
+		Entity{Key: key(1), Properties: datastore.PropertyList{{Name: "A", Value: "a"}, {Name: "T", Value: dt}}},
+		Entity{Key: key(2), Properties: datastore.PropertyList{{Name: "A", Value: "b"}, {Name: "B", Value: []byte{1}}}},
+		Entity{Key: key(3), Properties: datastore.PropertyList{{Name: "N", Value: int64(1)}}},
+	)
+	b := encodeEntities(t, nil,
+		Entity{Key: key(4), Properties: datastore.PropertyList{{Name: "N", Value: int64(4)}}},
+		Entity{Key: key(2), Properties: datastore.PropertyList{{Name: "A", Value: "b"}, {Name: "B", Value: []byte{1}}}},
+		Entity{Key: key(1), Properties: datastore.PropertyList{{Name: "A", Value: "x"}, {Name: "C", Value: 1.5}}},
+	)
+	var changes []*Change
+	require.NoError(t, Diff(a, b, func(c *Change) error {
+		changes = append(changes, c)
+		return nil
+	}))
+	require.Len(t, changes, 3)
+	assert.Equal(t, "+ /Test,4\n", FormatChange(changes[0]))
+	assert.Equal(t, "~ /Test,1\n"+
+		"    A: 'a' -> 'x'\n"+
+		"    C: (missing) -> 1.5\n"+
+		"    T: DATETIME('2024-05-01T00:00:00Z') -> (missing)\n", FormatChange(changes[1]))
+	assert.Equal(t, "- /Test,3\n", FormatChange(changes[2]))
+	assert.Equal(t, []PropertyChange{{Name: "N", Old: TypedValues{int64(1)}}}, changes[2].Properties)
+
+	b2, err := json.Marshal(changes[1])
+	require.NoError(t, err)
+	assert.Equal(t, `{"key":"/Test,1","op":"modify","properties":[`+
+		`{"name":"A","old":["string:a"],"new":["string:x"]},`+
+		`{"name":"C","new":["float:1.5"]},`+
+		`{"name":"T","old":["time:2024-05-01T00:00:00Z"]}]}`, string(b2))
+	var c Change
+	require.NoError(t, json.Unmarshal(b2, &c))
+	assert.Equal(t, key(1), c.Key)
+	assert.Equal(t, changes[1].Properties, c.Properties)
+}
+
+func TestPatchEntity(t *testing.T) {
+	key := datastore.IDKey("Test", 1, nil)
+	c := &Change{Op: ChangeModify, Key: key,
+		Properties: []PropertyChange{
+			{Name: "A", Old: TypedValues{"a"}, New: TypedValues{"x"}, NoIndex: true},
+			{Name: "L", Old: TypedValues{"p"}, New: TypedValues{"p", "q"}},
+			{Name: "T", Old: TypedValues{int64(1)}},
+		},
+		Before: datastore.PropertyList{{Name: "A", Value: "a"}, {Name: "L", Value: []any{"p"}}, {Name: "T", Value: int64(1)}},
+		After:  datastore.PropertyList{{Name: "A", Value: "x", NoIndex: true}, {Name: "L", Value: []any{"p", "q"}}},
+	}
+	assert.Equal(t, Entity{Key: key, Properties: datastore.PropertyList{
+		{Name: "__op__", Value: "modify", NoIndex: true},
+		{Name: "A", Value: "x", NoIndex: true},
+		{Name: "__old__.A", Value: "a", NoIndex: true},
+		{Name: "L", Value: []any{"p", "q"}},
+		{Name: "__old__.L", Value: []any{"p"}, NoIndex: true}, // arrays stay arrays
+		{Name: "__old__.T", Value: int64(1), NoIndex: true},
+	}}, PatchEntity(c))
+}
@@ -0,0 +1,150 @@
+package dsio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// KeyFuncType is the type of the callback returning the DataStore key of an exported object.
+type KeyFuncType func(src any) (*datastore.Key, error)
+
+// Encoder writes Go structs into a .ds stream.
+type Encoder struct {
+	// KeyFunc returns the key of a given struct pointer.
+	// If nil, the key is taken from the struct field tagged `datastore:"__key__"`.
+	KeyFunc KeyFuncType
+	wbuf    *bufio.Writer
+	m       *marshaler
+}
+
+// NewEncoder returns a new Encoder writing to w. Flush must be called after the last Encode.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		wbuf: bufio.NewWriterSize(w, 32768),
+		m:    newMarshaler(),
+	}
+}
+
+// Encode writes a single struct pointer, honouring datastore struct tags.
+// If src implements datastore.PropertyLoadSaver, its Save method is used instead, the same way datastore.Put does.
+func (enc *Encoder) Encode(src any) error {
+	var props []datastore.Property
+	var err error
+	if pls, ok := src.(datastore.PropertyLoadSaver); ok {
+		props, err = pls.Save()
+	} else {
+		props, err = datastore.SaveStruct(src)
+	}
+	if err != nil {
+		return err
+	}
+	rec := Entity{Properties: make(datastore.PropertyList, 0, len(props))}
+	for _, p := range props {
+		if p.Name == "__key__" {
+			rec.Key, _ = p.Value.(*datastore.Key)
+			continue
+		}
+		rec.Properties = append(rec.Properties, p)
+	}
+	if enc.KeyFunc != nil {
+		rec.Key, err = enc.KeyFunc(src)
+		if err != nil {
+			return err
+		}
+	}
+	if rec.Key == nil {
+		return fmt.Errorf("Missing key for %T", src)
+	}
+	return enc.EncodeEntity(rec)
+}
+
+// WriteMetadata writes the stream metadata. It must be called before the first Encode.
+func (enc *Encoder) WriteMetadata(meta *Metadata) error {
+	return writeMetadata(enc.wbuf, meta)
+}
+
+// EncodeEntity writes a single DataStore entity.
+// Properties with a nil value are omitted, as the export format can't represent an untyped null.
+func (enc *Encoder) EncodeEntity(rec Entity) error {
+	props := make(datastore.PropertyList, 0, len(rec.Properties))
+	for _, p := range rec.Properties {
+		if p.Value == nil {
+			continue
+		}
+		if !encodable(p.Value) {
+			return fmt.Errorf("Unsupported data type '%T' of property %q", p.Value, p.Name)
+		}
+		props = append(props, p)
+	}
+	rec.Properties = props
+	header, row, err := enc.m.marshal(rec)
+	if err != nil {
+		return err
+	}
+	if header != nil {
+		if err = enc.writeLine(header); err != nil {
+			return err
+		}
+	}
+	return enc.writeLine(row)
+}
+
+// encodable reports whether a value can be written to an export and read back by Unmarshal.
+// Array elements are read back as plain JSON values, e.g. timestamps as strings.
+func encodable(value any) bool {
+	switch v := value.(type) {
+	case bool, int64, float64, string, time.Time, []byte:
+		return true
+	case []any:
+		for _, e := range v {
+			if _, nested := e.([]any); nested || e != nil && !encodable(e) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (enc *Encoder) writeLine(b []byte) error {
+	if _, err := enc.wbuf.Write(b); err != nil {
+		return err
+	}
+	return enc.wbuf.WriteByte('\n')
+}
+
+// Flush writes any buffered data to the underlying stream.
+func (enc *Encoder) Flush() error {
+	return enc.wbuf.Flush()
+}
+
+// ExportFileReflect exports the given struct pointers into a .ds file.
+func ExportFileReflect(filename string, rows []any, keyFunc KeyFuncType) (err error) {
+	outfile, err := OpenForWriting(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err2 := outfile.Close(); err == nil {
+			err = err2
+		}
+	}()
+	return ExportStreamReflect(outfile, rows, keyFunc)
+}
+
+// ExportStreamReflect exports the given struct pointers into a .ds stream.
+// keyFunc may be nil if the structs have a `datastore:"__key__"` field.
+func ExportStreamReflect(w io.Writer, rows []any, keyFunc KeyFuncType) error {
+	enc := NewEncoder(w)
+	enc.KeyFunc = keyFunc
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return enc.Flush()
+}
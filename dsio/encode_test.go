@@ -0,0 +1,83 @@
+package dsio
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/require"
+)
+
+type encodeTest struct {
+	K    *datastore.Key `datastore:"__key__"`
+	Name string         `datastore:"name"`
+	Note string         `datastore:",noindex"`
+	Skip string         `datastore:"-"`
+	N    int
+	Dt   time.Time
+}
+
+func TestExportStreamReflect(t *testing.T) {
+	dt := time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	rows := []any{
+		&encodeTest{K: datastore.IDKey("E", 1, nil), Name: "a", Note: "n", Skip: "s", N: 5, Dt: dt},
+		&encodeTest{K: datastore.NameKey("E", "x", nil), Name: "b"},
+	}
+	buf := &bytes.Buffer{}
+	require.NoError(t, ExportStreamReflect(buf, rows, nil))
+	require.Equal(t, `{"FieldsFrom":0,"Fields":[{"n":"Dt","t":"time.Time","i":false},{"n":"N","t":"int64","i":false},{"n":"Note","t":"string","i":true},{"n":"name","t":"string","i":false}]}
+{"k":"/E,1","d":["2024-01-02T03:04:05.006Z",5,"n","a"]}
+{"k":"/E,x","d":["0001-01-01T00:00:00.000Z",0,"","b"]}
+`, buf.String())
+
+	outCh := make(chan Entity, 10)
+	errCh := make(chan error, 1)
+	var res []Entity
+	go func() {
+		defer close(errCh)
+		for e := range outCh {
+			res = append(res, e)
+		}
+	}()
+	require.NoError(t, ImportFile(buf, outCh, errCh))
+	require.Len(t, res, 2)
+	require.Equal(t, datastore.IDKey("E", 1, nil), res[0].Key)
+	require.Equal(t, datastore.PropertyList{
+		{Name: "Dt", Value: dt},
+		{Name: "N", Value: int64(5)},
+		{Name: "Note", Value: "n", NoIndex: true},
+		{Name: "name", Value: "a"},
+	}, res[0].Properties)
+}
+
+func TestExportStreamReflect_keyFunc(t *testing.T) {
+	buf := &bytes.Buffer{}
+	keyFunc := func(src any) (*datastore.Key, error) {
+		return datastore.NameKey("B", src.(*B).X, nil), nil
+	}
+	require.NoError(t, ExportStreamReflect(buf, []any{&B{X: "x"}}, keyFunc))
+	require.Contains(t, buf.String(), `{"k":"/B,x","d":["x"]}`)
+	require.EqualError(t, ExportStreamReflect(buf, []any{&B{X: "x"}}, nil), "Missing key for *dsio.B")
+}
+
+func TestEncoder_unsupportedType(t *testing.T) {
+	enc := NewEncoder(&bytes.Buffer{})
+	err := enc.EncodeEntity(Entity{Key: datastore.IDKey("A", 1, nil), Properties: datastore.PropertyList{{Name: "G", Value: datastore.GeoPoint{}}}})
+	require.EqualError(t, err, `Unsupported data type 'datastore.GeoPoint' of property "G"`)
+}
+
+func TestEncoder_EncodeEntity(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	key := datastore.IDKey("A", 1, nil)
+	require.NoError(t, enc.EncodeEntity(Entity{Key: key, Properties: datastore.PropertyList{
+		{Name: "N", Value: nil},
+		{Name: "T", Value: []any{"a", int64(1), nil}},
+	}}))
+	require.NoError(t, enc.Flush())
+	require.Equal(t, `{"FieldsFrom":0,"Fields":[{"n":"T","t":"[]interface {}","i":false}]}`+"\n"+`{"k":"/A,1","d":[["a",1,null]]}`+"\n", buf.String())
+
+	err := enc.EncodeEntity(Entity{Key: key, Properties: datastore.PropertyList{{Name: "K", Value: []any{key}}}})
+	require.EqualError(t, err, `Unsupported data type '[]interface {}' of property "K"`)
+}
@@ -21,8 +21,90 @@ type Entity struct {
 
 // Export exports the given DataStore entity iterator into the given stream.
 func Export(it *datastore.Iterator, w io.Writer) (err error) {
+	return exportEntities(iteratorSource(it), w, nil)
+}
+
+// ExportQuery exports the entities matching the given query into the given stream.
+// If fields is not empty, only the given properties are exported. They are selected client-side from the full entities,
+// as a projection query would skip unindexed properties and entities missing a property, and split array values.
+// If q.ReadTime is set, all entities are read from a snapshot at that time.
+// Partial exports and the read time are recorded in the stream metadata.
+func ExportQuery(ds *datastore.Client, q *Query, fields []string, w io.Writer) error {
+	return exportQuery(ds, q, fields, w, nil)
+}
+
+// exportQuery implements ExportQuery, calling observe (if not nil) for each exported entity.
+func exportQuery(ds *datastore.Client, q *Query, fields []string, w io.Writer, observe func(Entity)) error {
+	var meta *Metadata
+	if len(fields) > 0 || len(q.Projection) > 0 || q.KeysOnly {
+		meta = &Metadata{Partial: true, Fields: fields}
+		if len(fields) == 0 {
+			meta.Fields = q.Projection
+		}
+	}
+	if !q.ReadTime.IsZero() {
+		if meta == nil {
+			meta = &Metadata{}
+		}
+		readTime := q.ReadTime.UTC()
+		meta.ReadTime = &readTime
+	}
+	var src entitySource
+	if len(fields) == 0 || len(q.Projection) > 0 {
+		src = q.run(ds)
+	} else {
+		src = fieldsSource(q.run(ds), fields)
+	}
+	if observe != nil {
+		next := src
+		src = func() (Entity, error) {
+			rec, err := next()
+			if err == nil {
+				observe(rec)
+			}
+			return rec, err
+		}
+	}
+	return exportEntities(src, w, meta)
+}
+
+// fieldsSource returns the entities of src having only the given properties.
+func fieldsSource(src entitySource, fields []string) entitySource {
+	keep := make(map[string]bool)
+	for _, f := range fields {
+		keep[f] = true
+	}
+	return func() (Entity, error) {
+		rec, err := src()
+		props := rec.Properties[:0]
+		for _, p := range rec.Properties {
+			if keep[p.Name] {
+				props = append(props, p)
+			}
+		}
+		rec.Properties = props
+		return rec, err
+	}
+}
+
+// entitySource returns the next entity to export, or iterator.Done at the end.
+type entitySource func() (Entity, error)
+
+func iteratorSource(it *datastore.Iterator) entitySource {
+	return func() (rec Entity, err error) {
+		rec.Key, err = it.Next(&rec.Properties)
+		return
+	}
+}
+
+func exportEntities(next entitySource, w io.Writer, meta *Metadata) (err error) {
 	wbuf := bufio.NewWriterSize(w, 32768)
 	defer wbuf.Flush()
+	if meta != nil {
+		if err = writeMetadata(wbuf, meta); err != nil {
+			return err
+		}
+	}
 	inCh := make(chan Entity, 10)
 	outCh := make(chan []byte, 10)
 	errCh := make(chan error, 1)
@@ -43,8 +125,8 @@ func Export(it *datastore.Iterator, w io.Writer) (err error) {
 	}()
 outer:
 	for {
-		rec := Entity{}
-		rec.Key, err = it.Next(&rec.Properties)
+		var rec Entity
+		rec, err = next()
 		if err != nil {
 			if err == iterator.Done {
 				err = nil
@@ -55,16 +137,18 @@ outer:
 		case inCh <- rec:
 		case err = <-errCh:
 			break outer
+		case err = <-werrCh:
+			break outer
 		}
 	}
 	close(inCh)
+	err3 := <-werrCh // wait for completion
 	go func() {
 		for range outCh {
-			// drain outCh
+			// drain outCh in case the writer has quit early
 		}
 	}()
-	err2 := <-errCh  // catch possible error at last line
-	err3 := <-werrCh // wait for completion
+	err2 := <-errCh // catch possible error at last line
 	if err == nil {
 		err = err2
 	}
@@ -78,48 +162,66 @@ outer:
 func Marshal(inCh <-chan Entity, outCh chan<- []byte, errCh chan<- error) {
 	defer close(errCh)
 	defer close(outCh)
-	fields := make(map[string]jsonField)
+	m := newMarshaler()
 	for rec := range inCh {
-		sort.Slice(rec.Properties, func(a, b int) bool {
-			return rec.Properties[a].Name < rec.Properties[b].Name
-		})
-		newfields := jsonFields{}
-		row := jsonRow{Key: MarshalKey(rec.Key)}
-		for _, p := range rec.Properties {
-			f, ok := fields[p.Name]
-			if !ok {
-				fields[p.Name] = jsonField{Name: p.Name, Type: fmt.Sprint(reflect.TypeOf(p.Value)), NoIndex: p.NoIndex, idx: len(fields)}
-				f = fields[p.Name]
-				if newfields.Fields == nil {
-					newfields.FieldsFrom = f.idx
-				}
-				newfields.Fields = append(newfields.Fields, f)
-			}
-			value := prepareForMarshal(p.Value)
-			if f.idx == len(row.Row) {
-				row.Row = append(row.Row, value)
-			} else {
-				if f.idx > len(row.Row) {
-					row.Row = append(row.Row, make([]any, f.idx-len(row.Row)+1)...)
-				}
-				row.Row[f.idx] = value
+		header, row, err := m.marshal(rec)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		if header != nil {
+			outCh <- header
+		}
+		outCh <- row
+	}
+}
+
+type marshaler struct {
+	fields map[string]jsonField
+}
+
+func newMarshaler() *marshaler {
+	return &marshaler{fields: make(map[string]jsonField)}
+}
+
+// marshal returns the encoded entity row, preceded by a header if the entity introduces new fields.
+func (m *marshaler) marshal(rec Entity) (header, row []byte, err error) {
+	sort.Slice(rec.Properties, func(a, b int) bool {
+		return rec.Properties[a].Name < rec.Properties[b].Name
+	})
+	newfields := jsonFields{}
+	r := jsonRow{Key: MarshalKey(rec.Key)}
+	for _, p := range rec.Properties {
+		f, ok := m.fields[p.Name]
+		if !ok {
+			m.fields[p.Name] = jsonField{Name: p.Name, Type: fmt.Sprint(reflect.TypeOf(p.Value)), NoIndex: p.NoIndex, idx: len(m.fields)}
+			f = m.fields[p.Name]
+			if newfields.Fields == nil {
+				newfields.FieldsFrom = f.idx
 			}
+			newfields.Fields = append(newfields.Fields, f)
 		}
-		if len(newfields.Fields) != 0 {
-			b, err := json.Marshal(newfields)
-			if err != nil {
-				errCh <- err
-				return
+		value := prepareForMarshal(p.Value)
+		if f.idx == len(r.Row) {
+			r.Row = append(r.Row, value)
+		} else {
+			if f.idx > len(r.Row) {
+				r.Row = append(r.Row, make([]any, f.idx-len(r.Row)+1)...)
 			}
-			outCh <- b
+			r.Row[f.idx] = value
 		}
-		b, err := json.Marshal(row)
+	}
+	if len(newfields.Fields) != 0 {
+		header, err = json.Marshal(newfields)
 		if err != nil {
-			errCh <- err
-			return
+			return nil, nil, err
 		}
-		outCh <- b
 	}
+	row, err = json.Marshal(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, row, nil
 }
 
 func prepareForMarshal(value any) any {
@@ -0,0 +1,78 @@
+package dsio
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/iterator"
+)
+
+// sliceSource returns the given entities.
+func sliceSource(entities []Entity) entitySource {
+	return func() (Entity, error) {
+		if len(entities) == 0 {
+			return Entity{}, iterator.Done
+		}
+		rec := entities[0]
+		entities = entities[1:]
+		return rec, nil
+	}
+}
+
+// slowWriter sleeps on each write, and fails after the given number of writes if fail is set.
+type slowWriter struct {
+	bytes.Buffer
+	writes int
+	fail   int
+}
+
+func (w *slowWriter) Write(b []byte) (int, error) {
+	if w.writes++; w.fail > 0 && w.writes > w.fail {
+		return 0, errors.New("Disk full")
+	}
+	time.Sleep(time.Millisecond)
+	return w.Buffer.Write(b)
+}
+
+func TestExportEntities(t *testing.T) {
+	var entities []Entity
+	big := strings.Repeat("x", 40000) // larger than the write buffer, so every row is written through
+	for i := 1; i <= 50; i++ {
+		entities = append(entities, Entity{Key: datastore.IDKey("A", int64(i), nil), Properties: datastore.PropertyList{{Name: "s", Value: big}}})
+	}
+	w := &slowWriter{}
+	require.NoError(t, exportEntities(sliceSource(entities), w, nil))
+	assert.Equal(t, 51, strings.Count(w.String(), "\n")) // the header and all rows, none lost to draining
+
+	// a failing writer stops the export instead of blocking it
+	w = &slowWriter{fail: 3}
+	require.EqualError(t, exportEntities(sliceSource(entities), w, nil), "Disk full")
+}
+
+func TestFieldsSource(t *testing.T) {
+	entities := []Entity{
+		{Key: datastore.IDKey("A", 1, nil), Properties: datastore.PropertyList{
+			{Name: "a", Value: "x", NoIndex: true},
+			{Name: "b", Value: int64(1)},
+			{Name: "c", Value: []any{"p", "q"}},
+		}},
+		{Key: datastore.IDKey("A", 2, nil), Properties: datastore.PropertyList{{Name: "b", Value: int64(2)}}},
+	}
+	src := fieldsSource(sliceSource(entities), []string{"a", "c"})
+
+	rec, err := src()
+	require.NoError(t, err)
+	assert.Equal(t, datastore.PropertyList{{Name: "a", Value: "x", NoIndex: true}, {Name: "c", Value: []any{"p", "q"}}}, rec.Properties)
+	rec, err = src()
+	require.NoError(t, err)
+	assert.Equal(t, datastore.IDKey("A", 2, nil), rec.Key) // entities without the fields are kept
+	assert.Empty(t, rec.Properties)
+	_, err = src()
+	assert.Equal(t, iterator.Done, err)
+}
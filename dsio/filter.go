@@ -0,0 +1,297 @@
+package dsio
+
+import (
+	"strings"
+
+	"cloud.google.com/go/datastore"
+)
+
+// ValueFunc converts a filter literal of the given field into a DataStore value.
+// The literal is passed in the ParseTypedValue format: quoted strings are passed as "string:<text>".
+type ValueFunc func(field, literal string) (any, error)
+
+// ParseFilter parses a filter expression, e.g.
+//
+//	age >= int:30 AND (status = 'open' OR status IN ('new', 'on hold')) AND owner != KEY(User, 5)
+//
+// Supported operators are =, !=, <, <=, >, >=, IN and NOT IN; conditions can be combined with AND, OR and parentheses.
+// Conditions separated only by whitespace are combined with AND.
+// Unquoted values are converted with valueFunc; NULL is the null value.
+// Returns nil for an empty expression.
+func ParseFilter(s string, valueFunc ValueFunc) (datastore.EntityFilter, error) {
+	p := &filterParser{l: &lexer{s: s}, valueFunc: valueFunc}
+	t, err := p.l.peek()
+	if err != nil || t.kind == tokEOF {
+		return nil, err
+	}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t, err = p.l.next(); err != nil {
+		return nil, err
+	}
+	if t.kind != tokEOF {
+		return nil, p.l.errorf(t.pos, "unexpected %q", t.text)
+	}
+	return f, nil
+}
+
+type filterParser struct {
+	l         *lexer
+	valueFunc ValueFunc
+	// GQL mode: self-typed literals, @bindings and HAS ANCESTOR
+	gql      bool
+	bindings map[string]any
+	ancestor *datastore.Key
+}
+
+func isKeyword(t token, keyword string) bool {
+	return t.kind == tokIdent && strings.EqualFold(t.text, keyword)
+}
+
+func isOp(t token, op string) bool {
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *filterParser) or() (datastore.EntityFilter, error) {
+	var filters []datastore.EntityFilter
+	ancestor := p.ancestor
+	orPos := 0
+	for {
+		f, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+		t, err := p.l.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !isKeyword(t, "OR") {
+			break
+		}
+		orPos = t.pos
+		p.l.next()
+	}
+	if len(filters) > 1 && p.ancestor != ancestor {
+		return nil, p.l.errorf(orPos, "HAS ANCESTOR can't be combined with OR")
+	}
+	if len(filters) == 1 {
+		return filters[0], nil
+	}
+	return datastore.OrFilter{Filters: filters}, nil
+}
+
+func (p *filterParser) and() (datastore.EntityFilter, error) {
+	var filters []datastore.EntityFilter
+	for {
+		f, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			filters = append(filters, f)
+		}
+		t, err := p.l.peek()
+		if err != nil {
+			return nil, err
+		}
+		if isKeyword(t, "AND") {
+			p.l.next()
+		} else if p.gql || t.kind == tokEOF || isKeyword(t, "OR") || isOp(t, ")") {
+			break
+		}
+	}
+	switch len(filters) {
+	case 0:
+		return nil, nil // only HAS ANCESTOR
+	case 1:
+		return filters[0], nil
+	}
+	return datastore.AndFilter{Filters: filters}, nil
+}
+
+func (p *filterParser) primary() (datastore.EntityFilter, error) {
+	t, err := p.l.next()
+	if err != nil {
+		return nil, err
+	}
+	if isOp(t, "(") {
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t, err = p.l.next(); err != nil {
+			return nil, err
+		}
+		if !isOp(t, ")") {
+			return nil, p.l.errorf(t.pos, "expected ')'")
+		}
+		return f, nil
+	}
+	field, err := p.fieldName(t)
+	if err != nil {
+		return nil, err
+	}
+	if t, err = p.l.next(); err != nil {
+		return nil, err
+	}
+	op := ""
+	switch {
+	case p.gql && isKeyword(t, "HAS"):
+		return p.hasAncestor()
+	case p.gql && isKeyword(t, "IS"):
+		t2, err := p.l.next()
+		if err != nil {
+			return nil, err
+		}
+		if !isKeyword(t2, "NULL") {
+			return nil, p.l.errorf(t2.pos, "expected NULL")
+		}
+		return datastore.PropertyFilter{FieldName: field, Operator: "=", Value: nil}, nil
+	case p.gql && isKeyword(t, "CONTAINS"):
+		op = "="
+	case t.kind == tokOp && strings.Contains(" = != < <= > >= ", " "+t.text+" "):
+		op = t.text
+	case isKeyword(t, "IN"):
+		op = "in"
+	case isKeyword(t, "NOT"):
+		t2, err := p.l.next()
+		if err != nil {
+			return nil, err
+		}
+		if !isKeyword(t2, "IN") {
+			return nil, p.l.errorf(t2.pos, "expected IN")
+		}
+		op = "not-in"
+	default:
+		return nil, p.l.errorf(t.pos, "expected operator after %q", field)
+	}
+	var value any
+	if op == "in" || op == "not-in" {
+		value, err = p.list(field)
+	} else {
+		value, err = p.value(field)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return datastore.PropertyFilter{FieldName: field, Operator: op, Value: value}, nil
+}
+
+// fieldName parses a possibly dotted property name starting with token t.
+func (p *filterParser) fieldName(t token) (string, error) {
+	if t.kind != tokIdent {
+		return "", p.l.errorf(t.pos, "expected property name")
+	}
+	name := t.text
+	for p.l.pos < len(p.l.s) && p.l.s[p.l.pos] == '.' {
+		p.l.pos++
+		t, err := p.l.next()
+		if err != nil {
+			return "", err
+		}
+		if t.kind != tokIdent {
+			return "", p.l.errorf(t.pos, "expected property name")
+		}
+		name += "." + t.text
+	}
+	return name, nil
+}
+
+func (p *filterParser) list(field string) (any, error) {
+	t, err := p.l.next()
+	if err != nil {
+		return nil, err
+	}
+	if p.gql && isKeyword(t, "ARRAY") {
+		if t, err = p.l.next(); err != nil {
+			return nil, err
+		}
+	} else if p.gql && isOp(t, "@") {
+		p.l.pos = t.pos
+		return p.gqlValue(field)
+	}
+	if !isOp(t, "(") {
+		return nil, p.l.errorf(t.pos, "expected '('")
+	}
+	var values []any
+	for {
+		v, err := p.value(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		if t, err = p.l.next(); err != nil {
+			return nil, err
+		}
+		if isOp(t, ")") {
+			return values, nil
+		}
+		if !isOp(t, ",") {
+			return nil, p.l.errorf(t.pos, "expected ',' or ')'")
+		}
+	}
+}
+
+// value parses a literal: 'quoted', "quoted", KEY(...), NULL, type:'quoted' or an unquoted word.
+// Unquoted words end at whitespace, ',' or ')'; in key: words a ',' only ends the word if followed by whitespace.
+func (p *filterParser) value(field string) (any, error) {
+	if p.gql {
+		return p.gqlValue(field)
+	}
+	l := p.l
+	t, err := l.peek()
+	if err != nil {
+		return nil, err
+	}
+	l.pos = t.pos
+	if t.kind == tokEOF || t.kind == tokOp && strings.Contains(",)", t.text) {
+		return nil, l.errorf(t.pos, "expected value for %q", field)
+	}
+	if isKeyword(t, "KEY") && strings.HasPrefix(strings.TrimLeft(l.s[t.pos+3:], " \t"), "(") {
+		return l.parseKeyLiteral()
+	}
+	typ, _ := splitTypePrefix(l.s[l.pos:])
+	if typ != "" {
+		l.pos += len(typ) + 1
+	}
+	literal := ""
+	if l.pos < len(l.s) && (l.s[l.pos] == '\'' || l.s[l.pos] == '"') {
+		text, err := l.quoted(l.s[l.pos])
+		if err != nil {
+			return nil, err
+		}
+		if typ == "" {
+			typ = "string"
+		}
+		literal = typ + ":" + text
+	} else {
+		start := l.pos
+		for l.pos < len(l.s) {
+			c := l.s[l.pos]
+			if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ')' ||
+				c == ',' && (typ != "key" || l.pos+1 == len(l.s) || strings.IndexByte(" \t\r\n", l.s[l.pos+1]) >= 0) {
+				break
+			}
+			l.pos++
+		}
+		word := l.s[start:l.pos]
+		if word == "" {
+			return nil, l.errorf(start, "expected value for %q", field)
+		}
+		if typ == "" && strings.EqualFold(word, "NULL") {
+			return nil, nil
+		}
+		literal = word
+		if typ != "" {
+			literal = typ + ":" + word
+		}
+	}
+	v, err := p.valueFunc(field, literal)
+	if err != nil {
+		return nil, l.errorf(t.pos, "%v", err)
+	}
+	return v, nil
+}
@@ -0,0 +1,56 @@
+package dsio
+
+import (
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/require"
+)
+
+func testValueFunc(field, literal string) (any, error) {
+	return ParseTypedValue(literal, nil)
+}
+
+func TestParseFilter(t *testing.T) {
+	f, err := ParseFilter(`age >= int:30 AND (status = 'it''s open' OR status IN ("new", on-hold)) AND owner != KEY(User, 5)`, testValueFunc)
+	require.NoError(t, err)
+	require.Equal(t, datastore.AndFilter{Filters: []datastore.EntityFilter{
+		datastore.PropertyFilter{FieldName: "age", Operator: ">=", Value: int64(30)},
+		datastore.OrFilter{Filters: []datastore.EntityFilter{
+			datastore.PropertyFilter{FieldName: "status", Operator: "=", Value: "it's open"},
+			datastore.PropertyFilter{FieldName: "status", Operator: "in", Value: []any{"new", "on-hold"}},
+		}},
+		datastore.PropertyFilter{FieldName: "owner", Operator: "!=", Value: datastore.IDKey("User", 5, nil)},
+	}}, f)
+
+	f, err = ParseFilter(`a.b=x c<key:/User,5 d not in (key:/A,1, key:'/A,2') e = null`, testValueFunc)
+	require.NoError(t, err)
+	require.Equal(t, datastore.AndFilter{Filters: []datastore.EntityFilter{
+		datastore.PropertyFilter{FieldName: "a.b", Operator: "=", Value: "x"},
+		datastore.PropertyFilter{FieldName: "c", Operator: "<", Value: datastore.IDKey("User", 5, nil)},
+		datastore.PropertyFilter{FieldName: "d", Operator: "not-in", Value: []any{datastore.IDKey("A", 1, nil), datastore.IDKey("A", 2, nil)}},
+		datastore.PropertyFilter{FieldName: "e", Operator: "=", Value: nil},
+	}}, f)
+
+	f, err = ParseFilter(`  `, testValueFunc)
+	require.NoError(t, err)
+	require.Nil(t, f)
+}
+
+func TestParseFilter_errors(t *testing.T) {
+	for _, tc := range []struct{ in, err string }{
+		{`a`, `Syntax error at position 1: expected operator after "a"`},
+		{`a =`, `Syntax error at position 3: expected value for "a"`},
+		{`(a = 1`, `Syntax error at position 6: expected ')'`},
+		{`a = 1)`, `Syntax error at position 5: unexpected ")"`},
+		{`a = 'x`, `Syntax error at position 4: unterminated string`},
+		{`a in 1`, `Syntax error at position 5: expected '('`},
+		{`a in (1 2)`, `Syntax error at position 8: expected ',' or ')'`},
+		{`a not 1`, `Syntax error at position 6: expected IN`},
+		{`a = int:x`, `Syntax error at position 4: Unable to parse 'x' as int`},
+		{`= 1`, `Syntax error at position 0: expected property name`},
+	} {
+		_, err := ParseFilter(tc.in, testValueFunc)
+		require.EqualError(t, err, tc.err, tc.in)
+	}
+}
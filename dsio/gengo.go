@@ -0,0 +1,169 @@
+package dsio
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var goTypes = map[string]string{
+	"string": "string",
+	"int":    "int64",
+	"float":  "float64",
+	"bool":   "bool",
+	"time":   "time.Time",
+	"key":    "*datastore.Key",
+	"blob":   "[]byte",
+}
+
+type goStruct struct {
+	name, doc string
+	fields    []goField
+}
+
+type goField struct {
+	name, typ, tag, comment string
+}
+
+type goGen struct {
+	structs []*goStruct
+	imports map[string]bool
+}
+
+// GenerateGo writes Go struct definitions for the given kinds, with `datastore` tags,
+// usable with datastore.Get and ImportFileReflect.
+// Repeated properties become slices and dotted property names become nested structs with the flatten option.
+func GenerateGo(w io.Writer, pkg string, kinds []*KindSchema) error {
+	g := &goGen{imports: make(map[string]bool)}
+	for _, ks := range kinds {
+		name := goIdent(ks.Kind)
+		g.addStruct(name, fmt.Sprintf("%s is the %s kind.", name, ks.Kind), ks.Properties, "", true)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by dsutil gen-go. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	if g.imports["time"] {
+		buf.WriteString("\t\"time\"\n\n")
+	}
+	buf.WriteString("\t\"cloud.google.com/go/datastore\"\n)\n")
+	for _, s := range g.structs {
+		fmt.Fprintf(&buf, "\n// %s\ntype %s struct {\n", s.doc, s.name)
+		for _, f := range s.fields {
+			fmt.Fprintf(&buf, "\t%s %s `datastore:%s`", f.name, f.typ, strconv.Quote(f.tag))
+			if f.comment != "" {
+				buf.WriteString(" // " + f.comment)
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString("}\n")
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// addStruct adds a struct for the given properties, whose names start with path.
+func (g *goGen) addStruct(name, doc string, props []*PropertySchema, path string, isKind bool) {
+	s := &goStruct{name: name, doc: doc}
+	g.structs = append(g.structs, s)
+	names := make(map[string]bool)
+	if isKind {
+		s.fields = append(s.fields, goField{name: uniqueIdent(names, "Key"), typ: "*datastore.Key", tag: "__key__"})
+	}
+	scalars := make(map[string]bool)
+	for _, p := range props {
+		if rel := p.Name[len(path):]; !strings.Contains(rel, ".") {
+			scalars[rel] = true
+		}
+	}
+	groups := make(map[string][]*PropertySchema)
+	var order []*PropertySchema // nil for nested structs
+	var nestedOrder []string
+	for _, p := range props {
+		rel := p.Name[len(path):]
+		if head, _, nested := strings.Cut(rel, "."); nested && !scalars[head] && head != "" {
+			if _, ok := groups[head]; !ok {
+				order = append(order, nil)
+				nestedOrder = append(nestedOrder, head)
+			}
+			groups[head] = append(groups[head], p)
+		} else {
+			order = append(order, p)
+		}
+	}
+	for _, p := range order {
+		if p == nil {
+			head := nestedOrder[0]
+			nestedOrder = nestedOrder[1:]
+			fieldName := uniqueIdent(names, goIdent(head))
+			nested := name + fieldName
+			s.fields = append(s.fields, goField{name: fieldName, typ: nested, tag: head + ",flatten"})
+			g.addStruct(nested, fmt.Sprintf("%s holds the %s.%s properties.", nested, name, head), groups[head], path+head+".", false)
+			continue
+		}
+		rel := p.Name[len(path):]
+		f := goField{name: uniqueIdent(names, goIdent(rel)), tag: rel}
+		types := slices.DeleteFunc(slices.Clone(p.Types), func(t string) bool { return t == "null" })
+		if t := p.arrayType(); t != "" {
+			types = []string{t}
+		}
+		if len(types) == 0 {
+			f.typ = "string"
+			f.comment = "unknown type"
+		} else if f.typ = goTypes[types[0]]; f.typ == "" {
+			f.typ = "string"
+			f.comment = "unsupported type " + types[0]
+		} else if len(types) > 1 {
+			f.comment = "also " + strings.Join(types[1:], ", ")
+		}
+		if f.typ == "time.Time" {
+			g.imports["time"] = true
+		}
+		if p.Repeated {
+			f.typ = "[]" + f.typ
+		}
+		if p.NoIndex {
+			f.tag += ",noindex"
+		}
+		s.fields = append(s.fields, f)
+	}
+}
+
+// goIdent returns an exported Go identifier for a name, e.g. "created_at" -> "CreatedAt".
+func goIdent(s string) string {
+	var sb strings.Builder
+	upper := true
+	for _, c := range s {
+		if !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			upper = true
+			continue
+		}
+		if upper {
+			c = unicode.ToUpper(c)
+			upper = false
+		}
+		sb.WriteRune(c)
+	}
+	id := sb.String()
+	if id == "" || !unicode.IsLetter([]rune(id)[0]) {
+		id = "X" + id
+	}
+	return id
+}
+
+// uniqueIdent returns id, with a number suffix if already used.
+func uniqueIdent(used map[string]bool, id string) string {
+	res := id
+	for n := 2; used[res]; n++ {
+		res = id + strconv.Itoa(n)
+	}
+	used[res] = true
+	return res
+}
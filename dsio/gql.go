@@ -0,0 +1,297 @@
+package dsio
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// ParseGQL parses a GQL query, e.g.
+//
+//	SELECT * FROM Order WHERE status = 'open' AND __key__ HAS ANCESTOR KEY(Customer, 5) ORDER BY created DESC LIMIT 10
+//
+// bindings supply the values of @name and @1 style parameters.
+// Supports DISTINCT [ON (...)], projections, SELECT __key__, WHERE with the ParseFilter operators as well as
+// CONTAINS, IS NULL and HAS ANCESTOR, ORDER BY, LIMIT [offset,] count and OFFSET.
+// Literals can be strings, numbers, TRUE, FALSE, NULL, KEY(...), DATETIME('...'), BLOB('...') and ARRAY(...).
+func ParseGQL(s string, bindings map[string]any) (*Query, error) {
+	p := &filterParser{l: &lexer{s: s}, gql: true, bindings: bindings}
+	q := &Query{}
+	if err := p.keyword("SELECT"); err != nil {
+		return nil, err
+	}
+	if p.optKeyword("DISTINCT") {
+		q.Distinct = true
+		if p.optKeyword("ON") {
+			q.Distinct = false
+			if err := p.op("("); err != nil {
+				return nil, err
+			}
+			names, err := p.fieldNames()
+			if err != nil {
+				return nil, err
+			}
+			q.DistinctOn = names
+			if err := p.op(")"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if t, _ := p.l.peek(); isOp(t, "*") {
+		p.l.next()
+	} else {
+		names, err := p.fieldNames()
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 1 && names[0] == "__key__" {
+			q.KeysOnly = true
+		} else {
+			q.Projection = names
+		}
+	}
+	if p.optKeyword("FROM") {
+		t, err := p.l.next()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != tokIdent {
+			return nil, p.l.errorf(t.pos, "expected kind")
+		}
+		q.Kind = t.text
+	}
+	if p.optKeyword("WHERE") {
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		q.Filter = f
+		if p.ancestor != nil {
+			q.Ancestor = p.ancestor
+			q.Namespace = p.ancestor.Namespace
+		}
+	}
+	if p.optKeyword("ORDER") {
+		if err := p.keyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			t, err := p.l.next()
+			if err != nil {
+				return nil, err
+			}
+			name, err := p.fieldName(t)
+			if err != nil {
+				return nil, err
+			}
+			if p.optKeyword("DESC") {
+				name = "-" + name
+			} else {
+				p.optKeyword("ASC")
+			}
+			q.Orders = append(q.Orders, name)
+			if t, _ := p.l.peek(); !isOp(t, ",") {
+				break
+			}
+			p.l.next()
+		}
+	}
+	if p.optKeyword("LIMIT") {
+		n, err := p.intValue()
+		if err != nil {
+			return nil, err
+		}
+		if t, _ := p.l.peek(); isOp(t, ",") {
+			p.l.next()
+			q.Offset = n
+			if n, err = p.intValue(); err != nil {
+				return nil, err
+			}
+		}
+		q.Limit = n
+	}
+	if p.optKeyword("OFFSET") {
+		n, err := p.intValue()
+		if err != nil {
+			return nil, err
+		}
+		q.Offset = n
+	}
+	t, err := p.l.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokEOF {
+		return nil, p.l.errorf(t.pos, "unexpected %q", t.text)
+	}
+	return q, nil
+}
+
+func (p *filterParser) keyword(keyword string) error {
+	t, err := p.l.next()
+	if err == nil && !isKeyword(t, keyword) {
+		err = p.l.errorf(t.pos, "expected %s", keyword)
+	}
+	return err
+}
+
+func (p *filterParser) optKeyword(keyword string) bool {
+	if t, err := p.l.peek(); err == nil && isKeyword(t, keyword) {
+		p.l.next()
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) op(op string) error {
+	t, err := p.l.next()
+	if err == nil && !isOp(t, op) {
+		err = p.l.errorf(t.pos, "expected '%s'", op)
+	}
+	return err
+}
+
+// fieldNames parses a comma-separated list of property names.
+func (p *filterParser) fieldNames() (names []string, err error) {
+	for {
+		t, err := p.l.next()
+		if err != nil {
+			return nil, err
+		}
+		name, err := p.fieldName(t)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if t, _ := p.l.peek(); !isOp(t, ",") {
+			return names, nil
+		}
+		p.l.next()
+	}
+}
+
+func (p *filterParser) intValue() (int, error) {
+	t, _ := p.l.peek()
+	v, err := p.gqlValue("")
+	if err != nil {
+		return 0, err
+	}
+	switch n := v.(type) {
+	case int64:
+		return int(n), nil
+	case int:
+		return n, nil
+	}
+	return 0, p.l.errorf(t.pos, "expected integer")
+}
+
+// hasAncestor parses the rest of a "__key__ HAS ANCESTOR <key>" condition.
+func (p *filterParser) hasAncestor() (datastore.EntityFilter, error) {
+	if err := p.keyword("ANCESTOR"); err != nil {
+		return nil, err
+	}
+	t, _ := p.l.peek()
+	v, err := p.gqlValue("__key__")
+	if err != nil {
+		return nil, err
+	}
+	key, ok := v.(*datastore.Key)
+	if !ok {
+		return nil, p.l.errorf(t.pos, "expected key")
+	}
+	if p.ancestor != nil {
+		return nil, p.l.errorf(t.pos, "only one HAS ANCESTOR condition is allowed")
+	}
+	p.ancestor = key
+	return nil, nil
+}
+
+// gqlValue parses a GQL literal or binding.
+func (p *filterParser) gqlValue(field string) (any, error) {
+	l := p.l
+	t, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	sign := ""
+	if isOp(t, "-") || isOp(t, "+") {
+		sign = t.text
+		if t, err = l.next(); err != nil {
+			return nil, err
+		}
+		if t.kind != tokInt && t.kind != tokFloat {
+			return nil, l.errorf(t.pos, "expected number")
+		}
+	}
+	switch {
+	case t.kind == tokString:
+		return t.text, nil
+	case t.kind == tokInt:
+		v, err := strconv.ParseInt(sign+t.text, 10, 64)
+		if err != nil {
+			return nil, l.errorf(t.pos, "invalid integer %s", t.text)
+		}
+		return v, nil
+	case t.kind == tokFloat:
+		v, err := strconv.ParseFloat(sign+t.text, 64)
+		if err != nil {
+			return nil, l.errorf(t.pos, "invalid number %s", t.text)
+		}
+		return v, nil
+	case isOp(t, "@"):
+		name, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if name.kind != tokIdent && name.kind != tokInt {
+			return nil, l.errorf(name.pos, "expected binding name")
+		}
+		v, ok := p.bindings[name.text]
+		if !ok {
+			return nil, l.errorf(t.pos, "missing binding @%s", name.text)
+		}
+		return v, nil
+	case isKeyword(t, "TRUE"):
+		return true, nil
+	case isKeyword(t, "FALSE"):
+		return false, nil
+	case isKeyword(t, "NULL"):
+		return nil, nil
+	case isKeyword(t, "KEY"):
+		l.pos = t.pos
+		return l.parseKeyLiteral()
+	case isKeyword(t, "DATETIME") || isKeyword(t, "BLOB"):
+		if err = p.op("("); err != nil {
+			return nil, err
+		}
+		arg, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if arg.kind != tokString {
+			return nil, l.errorf(arg.pos, "expected string")
+		}
+		if err = p.op(")"); err != nil {
+			return nil, err
+		}
+		if isKeyword(t, "BLOB") {
+			v, err := base64.StdEncoding.DecodeString(arg.text)
+			if err != nil {
+				return nil, l.errorf(arg.pos, "invalid base64 string")
+			}
+			return v, nil
+		}
+		v, err := time.Parse(time.RFC3339Nano, strings.Replace(arg.text, " ", "T", 1))
+		if err != nil {
+			return nil, l.errorf(arg.pos, "invalid DATETIME, expected RFC 3339 format")
+		}
+		return v, nil
+	}
+	if field != "" {
+		return nil, l.errorf(t.pos, "expected value for %q", field)
+	}
+	return nil, l.errorf(t.pos, "expected value")
+}
@@ -0,0 +1,67 @@
+package dsio
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGQL(t *testing.T) {
+	q, err := ParseGQL(`SELECT * FROM Order WHERE status = 'open' AND __key__ HAS ANCESTOR KEY(NAMESPACE('ns'), Customer, 5)
+		AND total >= -1.5 AND created < DATETIME('2024-01-01T00:00:00Z') AND tags CONTAINS @tag AND note IS NULL
+		AND prio IN ARRAY(1, 2) ORDER BY created DESC, total LIMIT 5, @1`, map[string]any{"tag": "x", "1": 10})
+	require.NoError(t, err)
+	ancestor := datastore.IDKey("Customer", 5, nil)
+	ancestor.Namespace = "ns"
+	require.Equal(t, &Query{
+		Kind:      "Order",
+		Namespace: "ns",
+		Ancestor:  ancestor,
+		Filter: datastore.AndFilter{Filters: []datastore.EntityFilter{
+			datastore.PropertyFilter{FieldName: "status", Operator: "=", Value: "open"},
+			datastore.PropertyFilter{FieldName: "total", Operator: ">=", Value: -1.5},
+			datastore.PropertyFilter{FieldName: "created", Operator: "<", Value: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+			datastore.PropertyFilter{FieldName: "tags", Operator: "=", Value: "x"},
+			datastore.PropertyFilter{FieldName: "note", Operator: "=", Value: nil},
+			datastore.PropertyFilter{FieldName: "prio", Operator: "in", Value: []any{int64(1), int64(2)}},
+		}},
+		Orders: []string{"-created", "total"},
+		Limit:  10,
+		Offset: 5,
+	}, q)
+
+	q, err = ParseGQL(`select distinct on (a) a, b.c from `+"`My Kind`"+` where x = true or y = false offset 3`, nil)
+	require.NoError(t, err)
+	require.Equal(t, &Query{
+		Kind:       "My Kind",
+		DistinctOn: []string{"a"},
+		Projection: []string{"a", "b.c"},
+		Filter: datastore.OrFilter{Filters: []datastore.EntityFilter{
+			datastore.PropertyFilter{FieldName: "x", Operator: "=", Value: true},
+			datastore.PropertyFilter{FieldName: "y", Operator: "=", Value: false},
+		}},
+		Offset: 3,
+	}, q)
+
+	q, err = ParseGQL(`SELECT __key__ WHERE __key__ HAS ANCESTOR KEY(A, 'x')`, nil)
+	require.NoError(t, err)
+	require.Equal(t, &Query{KeysOnly: true, Ancestor: datastore.NameKey("A", "x", nil)}, q)
+}
+
+func TestParseGQL_errors(t *testing.T) {
+	for _, tc := range []struct{ in, err string }{
+		{`DELETE FROM A`, `Syntax error at position 0: expected SELECT`},
+		{`SELECT * FROM A WHERE a = @x`, `Syntax error at position 26: missing binding @x`},
+		{`SELECT * FROM A WHERE a = 1 OR __key__ HAS ANCESTOR KEY(A, 1)`, `Syntax error at position 28: HAS ANCESTOR can't be combined with OR`},
+		{`SELECT * FROM A LIMIT 'x'`, `Syntax error at position 22: expected integer`},
+		{`SELECT * FROM A ORDER created`, `Syntax error at position 22: expected BY`},
+		{`SELECT * FROM A WHERE a = DATETIME('yesterday')`, `Syntax error at position 35: invalid DATETIME, expected RFC 3339 format`},
+		{`SELECT * FROM A WHERE a = b`, `Syntax error at position 26: expected value for "a"`},
+		{`SELECT * FROM A junk`, `Syntax error at position 16: unexpected "junk"`},
+	} {
+		_, err := ParseGQL(tc.in, nil)
+		require.EqualError(t, err, tc.err, tc.in)
+	}
+}
@@ -2,47 +2,136 @@ package dsio
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/datastore"
 )
 
 // Import imports DataStore entities from the export file.
+// Partial exports (see ExportQuery) are refused, since they would overwrite full entities; use ImportMerge instead.
 func Import(r io.Reader, ds *datastore.Client) (err error) {
-	outCh := make(chan Entity, 10)
-	errCh := make(chan error, 1)
-	go func() {
-		defer close(errCh)
-		var keys []*datastore.Key
-		var rows []datastore.PropertyList
-		batchSize := 200
-		for rec := range outCh {
-			keys = append(keys, rec.Key)
-			rows = append(rows, rec.Properties)
-			if len(rows) >= batchSize {
-				_, err := ds.PutMulti(context.Background(), keys, rows)
-				if err != nil {
-					errCh <- err
-					return
+	meta, r, err := ReadMetadata(r)
+	if err != nil {
+		return err
+	}
+	if meta != nil && meta.Partial {
+		return fmt.Errorf("Refusing to import a partial export (fields %v): it would overwrite full entities, use merge mode instead", meta.Fields)
+	}
+	if meta != nil && meta.Patch {
+		return errPatchImport
+	}
+	return putEntities(exportReader(r), ds)
+}
+
+var errPatchImport = errors.New("Refusing to import a patch, use apply instead")
+
+// ImportMerge imports DataStore entities from the export file, merging them into the existing entities:
+// only the properties present in the file are overwritten, other properties are kept.
+// The properties listed in deleteFields are removed from every imported entity.
+// Each batch is read and written back in a single transaction.
+func ImportMerge(r io.Reader, ds *datastore.Client, deleteFields []string) error {
+	meta, r, err := ReadMetadata(r)
+	if err != nil {
+		return err
+	}
+	if meta != nil && meta.Patch {
+		return errPatchImport
+	}
+	return mergeEntities(exportReader(r), ds, deleteFields)
+}
+
+// entityReader reads entities from a file, calling fn for each entity.
+type entityReader func(fn func(Entity) error) error
+
+func exportReader(r io.Reader) entityReader {
+	return func(fn func(Entity) error) error {
+		return readEntities(r, fn)
+	}
+}
+
+func putEntities(read entityReader, ds *datastore.Client) error {
+	return importBatches(read, func(keys []*datastore.Key, rows []datastore.PropertyList) error {
+		_, err := ds.PutMulti(context.Background(), keys, rows)
+		return err
+	})
+}
+
+func mergeEntities(read entityReader, ds *datastore.Client, deleteFields []string) error {
+	del := make(map[string]bool)
+	for _, f := range deleteFields {
+		del[f] = true
+	}
+	return importBatches(read, func(keys []*datastore.Key, rows []datastore.PropertyList) error {
+		_, err := ds.RunInTransaction(context.Background(), func(tx *datastore.Transaction) error {
+			existing := make([]datastore.PropertyList, len(keys))
+			err := tx.GetMulti(keys, existing)
+			var merr datastore.MultiError
+			if err != nil && !errors.As(err, &merr) {
+				return err
+			}
+			merged := make([]datastore.PropertyList, len(keys))
+			for i := range keys {
+				if merr != nil && merr[i] != nil && merr[i] != datastore.ErrNoSuchEntity {
+					return merr[i]
 				}
-				keys, rows = nil, nil
+				merged[i] = mergeProperties(existing[i], rows[i], del)
 			}
+			_, err = tx.PutMulti(keys, merged)
+			return err
+		})
+		return err
+	})
+}
+
+// mergeProperties overlays the properties of src over dst, removing the properties in del.
+// All values of a multi-valued property are replaced.
+func mergeProperties(dst, src datastore.PropertyList, del map[string]bool) datastore.PropertyList {
+	replaced := make(map[string]bool)
+	for _, p := range src {
+		replaced[p.Name] = true
+	}
+	var res datastore.PropertyList
+	for _, p := range dst {
+		if !replaced[p.Name] && !del[p.Name] {
+			res = append(res, p)
 		}
-		if len(rows) > 0 {
-			_, err := ds.PutMulti(context.Background(), keys, rows)
-			if err != nil {
-				errCh <- err
-			}
+	}
+	for _, p := range src {
+		if !del[p.Name] {
+			res = append(res, p)
 		}
-	}()
-	err = ImportFile(r, outCh, errCh)
-	return
+	}
+	return res
+}
+
+// importBatches reads entities, passing them to putFunc in batches.
+func importBatches(read entityReader, putFunc func(keys []*datastore.Key, rows []datastore.PropertyList) error) error {
+	var keys []*datastore.Key
+	var rows []datastore.PropertyList
+	batchSize := 200
+	err := read(func(rec Entity) error {
+		keys = append(keys, rec.Key)
+		rows = append(rows, rec.Properties)
+		if len(rows) >= batchSize {
+			err := putFunc(keys, rows)
+			keys, rows = nil, nil
+			return err
+		}
+		return nil
+	})
+	if err == nil && len(rows) > 0 {
+		err = putFunc(keys, rows)
+	}
+	return err
 }
 
 // ImportFile reads an export file, writing DataStore entities to outCh.
@@ -66,13 +155,13 @@ outer:
 		}
 	}
 	close(inCh)
+	err3 := <-errCh // wait for completion
 	go func() {
 		for range outCh {
-			// drain outCh
+			// drain outCh in case the consumer has quit early
 		}
 	}()
 	err2 := <-ierrCh // catch possible error at last line
-	err3 := <-errCh  // wait for completion
 	if err == nil {
 		err = err2
 	}
@@ -92,6 +181,7 @@ func Unmarshal(inCh <-chan []byte, outCh chan<- Entity, errCh chan<- error) {
 	e := struct {
 		jsonRowReader
 		jsonFields
+		ndjsonEntity
 	}{}
 	linenr := 0
 	for b := range inCh {
@@ -103,7 +193,8 @@ func Unmarshal(inCh <-chan []byte, outCh chan<- Entity, errCh chan<- error) {
 			}
 		}
 		e.Fields = nil
-		e.Key = ""
+		e.jsonRowReader.Key = ""
+		e.ndjsonEntity = ndjsonEntity{}
 		for i := range e.Row {
 			e.Row[i].value = nil
 		}
@@ -120,10 +211,24 @@ func Unmarshal(inCh <-chan []byte, outCh chan<- Entity, errCh chan<- error) {
 			}
 			e.Row = nil
 		}
-		if e.Key == "" || len(e.Row) == 0 {
+		if e.ndjsonEntity.Key != nil {
+			rec, err := e.ndjsonEntity.entity()
+			if err != nil {
+				errCh <- fmt.Errorf("line %d: %v", linenr, err)
+				return
+			}
+			outCh <- rec
+			continue
+		}
+		if e.jsonRowReader.Key == "" || len(e.Row) == 0 {
 			continue
 		}
-		rec := Entity{Key: UnmarshalKey(e.Key)}
+		key, err := ParseKey(e.jsonRowReader.Key)
+		if err != nil {
+			errCh <- fmt.Errorf("line %d: %v", linenr, err)
+			return
+		}
+		rec := Entity{Key: key}
 		for i, v := range e.Row {
 			if v.value == nil {
 				continue
@@ -170,8 +275,10 @@ func (v *valueWrapper) UnmarshalJSON(b []byte) (err error) {
 		}
 	case "[]uint8":
 		if len(b) > 2 && b[0] == '"' {
-			v.value, err = base64.RawStdEncoding.DecodeString(string(b[1 : len(b)-1]))
+			v.value, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(string(b[1:len(b)-1]), "="))
 		}
+	case "[]interface {}":
+		v.value, err = unmarshalArray(b)
 	default:
 		err = fmt.Errorf("Unsupported data type '%s'", v.typ)
 	}
@@ -180,3 +287,28 @@ func (v *valueWrapper) UnmarshalJSON(b []byte) (err error) {
 	}
 	return
 }
+
+// unmarshalArray decodes an array value. The element types aren't recorded in the export,
+// so numbers are decoded as int64 or float64, and timestamps, keys and blobs remain strings.
+func unmarshalArray(b []byte) ([]any, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var arr []any
+	if err := d.Decode(&arr); err != nil {
+		return nil, err
+	}
+	for i, e := range arr {
+		switch e := e.(type) {
+		case json.Number:
+			if n, err := e.Int64(); err == nil {
+				arr[i] = n
+			} else if arr[i], err = e.Float64(); err != nil {
+				return nil, err
+			}
+		case string, bool, nil:
+		default:
+			return nil, fmt.Errorf("Unsupported array element %v", e)
+		}
+	}
+	return arr, nil
+}
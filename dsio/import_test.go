@@ -1,6 +1,9 @@
 package dsio
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,9 +24,10 @@ func TestUnmarshal(t *testing.T) {
 		{"n":"Flt","t":"float64","i":false},
 		{"n":"Dt","t":"time.Time","i":false},
 		{"n":"Bool","t":"bool","i":false},
-		{"n":"Bin","t":"[]uint8","i":false}
+		{"n":"Bin","t":"[]uint8","i":false},
+		{"n":"Bin2","t":"[]uint8","i":false}
 	]}`)
-	inCh <- []byte(`{"k":"/Test,1","d":["Test str \"A\"",null,123,123.12,"2006-01-02T15:04:05.012Z",true,"AQID"]}`)
+	inCh <- []byte(`{"k":"/Test,1","d":["Test str \"A\"",null,123,123.12,"2006-01-02T15:04:05.012Z",true,"AQID","AQ=="]}`)
 	close(inCh)
 	require.NoError(t, <-errCh)
 	res := <-outCh
@@ -37,5 +41,83 @@ func TestUnmarshal(t *testing.T) {
 		{Name: "Dt", Value: dt},
 		{Name: "Bool", Value: true},
 		{Name: "Bin", Value: []byte{1, 2, 3}},
+		{Name: "Bin2", Value: []byte{1}},
 	}, res.Properties)
 }
+
+func TestUnmarshal_invalidKey(t *testing.T) {
+	inCh := make(chan []byte, 10)
+	outCh := make(chan Entity, 10)
+	errCh := make(chan error, 2)
+	go Unmarshal(inCh, outCh, errCh)
+	inCh <- []byte(`{"FieldsFrom":0,"Fields":[{"n":"Str","t":"string","i":false}]}`)
+	inCh <- []byte(`{"k":"/Test,1","d":["a"]}`)
+	inCh <- []byte(`{"k":"/Test,","d":["b"]}`)
+	close(inCh)
+	require.EqualError(t, <-errCh, `line 3: Invalid key "/Test," at position 6: missing ID or name`)
+}
+
+func TestMergeProperties(t *testing.T) {
+	dst := datastore.PropertyList{
+		{Name: "A", Value: "a"},
+		{Name: "B", Value: int64(1)},
+		{Name: "B", Value: int64(2)},
+		{Name: "C", Value: true},
+		{Name: "D", Value: "d"},
+	}
+	src := datastore.PropertyList{
+		{Name: "B", Value: int64(3)},
+		{Name: "E", Value: "e"},
+	}
+	res := mergeProperties(dst, src, map[string]bool{"D": true})
+	assert.Equal(t, datastore.PropertyList{
+		{Name: "A", Value: "a"},
+		{Name: "C", Value: true},
+		{Name: "B", Value: int64(3)},
+		{Name: "E", Value: "e"},
+	}, res)
+	assert.Equal(t, src, mergeProperties(nil, src, nil))
+}
+
+func TestUnmarshalArray(t *testing.T) {
+	inCh := make(chan []byte, 10)
+	outCh := make(chan Entity, 10)
+	errCh := make(chan error, 2)
+	go Unmarshal(inCh, outCh, errCh)
+	inCh <- []byte(`{"FieldsFrom":0,"Fields":[{"n":"Arr","t":"[]interface {}","i":false}]}`)
+	inCh <- []byte(`{"k":"/Test,1","d":[["a",1,1.5,true,null]]}`)
+	close(inCh)
+	require.NoError(t, <-errCh)
+	res := <-outCh
+	assert.Equal(t, datastore.PropertyList{{Name: "Arr", Value: []any{"a", int64(1), 1.5, true, nil}}}, res.Properties)
+}
+
+func TestImportFile_slowConsumer(t *testing.T) {
+	var sb strings.Builder
+	sb.WriteString(`{"FieldsFrom":0,"Fields":[{"n":"N","t":"int64","i":false}]}` + "\n")
+	for i := 1; i <= 50; i++ {
+		fmt.Fprintf(&sb, `{"k":"/Test,%d","d":[%d]}`+"\n", i, i)
+	}
+	outCh := make(chan Entity, 10)
+	errCh := make(chan error, 1)
+	n := 0
+	go func() {
+		defer close(errCh)
+		for range outCh {
+			time.Sleep(time.Millisecond)
+			n++
+		}
+	}()
+	require.NoError(t, ImportFile(strings.NewReader(sb.String()), outCh, errCh))
+	assert.Equal(t, 50, n) // no entities are lost to draining
+
+	// a consumer quitting early stops the import with its error
+	outCh = make(chan Entity, 10)
+	errCh = make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		<-outCh
+		errCh <- errors.New("Consumer failed")
+	}()
+	require.EqualError(t, ImportFile(strings.NewReader(sb.String()), outCh, errCh), "Consumer failed")
+}
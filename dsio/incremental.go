@@ -0,0 +1,186 @@
+package dsio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// IncrementalState is the state of incremental exports, kept between runs.
+type IncrementalState struct {
+	Kind  string `json:"kind"`
+	Field string `json:"field"`
+	// Since is the high-water mark of Field from the previous run,
+	// as a literal with a type prefix (see FormatTypedValue), e.g. "time:2024-05-01T12:30:00Z".
+	Since string `json:"since,omitempty"`
+}
+
+// ReadIncrementalState reads the state of incremental exports.
+// Returns nil if the file doesn't exist.
+func ReadIncrementalState(filename string) (*IncrementalState, error) {
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := &IncrementalState{}
+	if err = json.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return st, nil
+}
+
+// WriteIncrementalState writes the state of incremental exports, replacing the file atomically.
+func WriteIncrementalState(filename string, st *IncrementalState) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err = os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// Restrict returns the query restricted to the entities changed since the high-water mark.
+func (st *IncrementalState) Restrict(q *Query) (*Query, error) {
+	iq := *q
+	if st.Since == "" {
+		return &iq, nil
+	}
+	since, err := ParseTypedValue(st.Since, nil)
+	if err != nil {
+		return nil, err
+	}
+	f := datastore.PropertyFilter{FieldName: st.Field, Operator: ">=", Value: since}
+	if q.Filter == nil {
+		iq.Filter = f
+	} else {
+		iq.Filter = datastore.AndFilter{Filters: []datastore.EntityFilter{q.Filter, f}}
+	}
+	return &iq, nil
+}
+
+// ExportIncremental exports the entities matching the query whose property st.Field is at least st.Since
+// (all entities if st.Since is empty), then advances st.Since to the greatest exported value.
+// Entities having exactly the previous high-water mark are exported again, so that none are missed.
+// Entities changed during the export may be missed unless q.ReadTime is set.
+func ExportIncremental(ds *datastore.Client, q *Query, fields []string, st *IncrementalState, w io.Writer) error {
+	iq, err := st.Restrict(q)
+	if err != nil {
+		return err
+	}
+	if len(fields) > 0 && !slices.Contains(fields, st.Field) {
+		fields = slices.Concat(fields, []string{st.Field})
+	}
+	var mark any
+	err = exportQuery(ds, iq, fields, w, func(rec Entity) {
+		mark = highWaterMark(mark, rec, st.Field)
+	})
+	if err != nil || mark == nil {
+		return err
+	}
+	st.Since, err = FormatTypedValue(mark)
+	return err
+}
+
+// highWaterMark returns the greater of mark and the values of the given entity property.
+func highWaterMark(mark any, rec Entity, field string) any {
+	for _, p := range rec.Properties {
+		if p.Name != field || p.Value == nil {
+			continue
+		}
+		if c, ok := compareValues(p.Value, mark); mark == nil || ok && c > 0 {
+			mark = p.Value
+		}
+	}
+	return mark
+}
+
+// MergeIncremental merges a base export with incremental exports (oldest first) into w,
+// writing the latest version of each entity. Entities only present in the incremental exports are written last.
+// Deleted entities can't be detected from incremental exports and are kept.
+// The incremental entities are kept in a temporary file, with only their keys in memory.
+// The read time of the merged export is that of the latest incremental export, if recorded.
+func MergeIncremental(base io.Reader, incs []io.Reader, w io.Writer) error {
+	meta, base, err := ReadMetadata(base)
+	if err != nil {
+		return err
+	}
+	if meta != nil && meta.Partial {
+		return errors.New("Can't merge a partial base export")
+	}
+	var readTime *time.Time
+	if meta != nil {
+		readTime = meta.ReadTime
+	}
+	latest, err := newEntitySpill()
+	if err != nil {
+		return err
+	}
+	defer latest.close()
+	for i, r := range incs {
+		m, r, err := ReadMetadata(r)
+		if err != nil {
+			return err
+		}
+		if m != nil && m.Partial {
+			return fmt.Errorf("Can't merge partial incremental export #%d", i+1)
+		}
+		readTime = nil
+		if m != nil {
+			readTime = m.ReadTime
+		}
+		if err = readEntities(r, latest.add); err != nil {
+			return err
+		}
+	}
+	enc := NewEncoder(w)
+	if readTime != nil {
+		if err = enc.WriteMetadata(&Metadata{ReadTime: readTime}); err != nil {
+			return err
+		}
+	}
+	err = readEntities(base, func(rec Entity) error {
+		l, ok, err := latest.take(MarshalKey(rec.Key))
+		if err != nil {
+			return err
+		}
+		if ok {
+			rec = l
+		}
+		return enc.EncodeEntity(rec)
+	})
+	if err != nil {
+		return err
+	}
+	if err = latest.rest(enc.EncodeEntity); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// readEntities reads an export stream, calling fn for each entity.
+func readEntities(r io.Reader, fn func(Entity) error) error {
+	outCh := make(chan Entity, 10)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		for rec := range outCh {
+			if err := fn(rec); err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+	return ImportFile(r, outCh, errCh)
+}
@@ -0,0 +1,108 @@
+package dsio
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncrementalState(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state.json")
+	st, err := ReadIncrementalState(filename)
+	require.NoError(t, err)
+	assert.Nil(t, st)
+	st = &IncrementalState{Kind: "Event", Field: "updatedAt", Since: "time:2024-05-01T12:30:00Z"}
+	require.NoError(t, WriteIncrementalState(filename, st))
+	st2, err := ReadIncrementalState(filename)
+	require.NoError(t, err)
+	assert.Equal(t, st, st2)
+}
+
+func TestIncrementalRestrict(t *testing.T) {
+	q := &Query{Kind: "Event"}
+	st := &IncrementalState{Kind: "Event", Field: "updatedAt"}
+	iq, err := st.Restrict(q)
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM Event", iq.String())
+
+	st.Since = "time:2024-05-01T12:30:00Z"
+	iq, err = st.Restrict(q)
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM Event WHERE updatedAt >= DATETIME('2024-05-01T12:30:00Z')", iq.String())
+
+	q.Filter = datastore.PropertyFilter{FieldName: "type", Operator: "=", Value: "click"}
+	iq, err = st.Restrict(q)
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT * FROM Event WHERE type = 'click' AND updatedAt >= DATETIME('2024-05-01T12:30:00Z')", iq.String())
+	assert.Equal(t, "SELECT * FROM Event WHERE type = 'click'", q.String())
+}
+
+func TestHighWaterMark(t *testing.T) {
+	t1 := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	var mark any
+	for _, v := range []any{t2, nil, t1} {
+		mark = highWaterMark(mark, Entity{Properties: datastore.PropertyList{{Name: "At", Value: v}, {Name: "X", Value: t2.Add(time.Hour)}}}, "At")
+	}
+	assert.Equal(t, t2, mark)
+	s, err := FormatTypedValue(mark)
+	require.NoError(t, err)
+	assert.Equal(t, "time:2024-05-01T01:00:00Z", s)
+}
+
+func encodeEntities(t *testing.T, meta *Metadata, recs ...Entity) io.Reader {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if meta != nil {
+		require.NoError(t, enc.WriteMetadata(meta))
+	}
+	for _, rec := range recs {
+		require.NoError(t, enc.EncodeEntity(rec))
+	}
+	require.NoError(t, enc.Flush())
+	return &buf
+}
+
+func decodeEntities(t *testing.T, r io.Reader) (res []Entity) {
+	require.NoError(t, readEntities(r, func(rec Entity) error {
+		res = append(res, rec)
+		return nil
+	}))
+	return
+}
+
+func TestMergeIncremental(t *testing.T) {
+	entity := func(id int64, v string) Entity {
+		return Entity{Key: datastore.IDKey("Event", id, nil), Properties: datastore.PropertyList{{Name: "V", Value: v}}}
+	}
+	readTime := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	base := encodeEntities(t, nil, entity(1, "a"), entity(2, "b"), entity(3, "c"))
+	inc1 := encodeEntities(t, nil, entity(2, "b1"), entity(4, "d"))
+	inc2 := encodeEntities(t, &Metadata{ReadTime: &readTime}, entity(2, "b2"), entity(5, "e"), entity(1, "a2"))
+	var out bytes.Buffer
+	require.NoError(t, MergeIncremental(base, []io.Reader{inc1, inc2}, &out))
+
+	meta, r, err := ReadMetadata(&out)
+	require.NoError(t, err)
+	assert.Equal(t, readTime, *meta.ReadTime)
+	assert.Equal(t, []Entity{entity(1, "a2"), entity(2, "b2"), entity(3, "c"), entity(4, "d"), entity(5, "e")}, decodeEntities(t, r))
+
+	// the base read time is stale after merging an incremental export without one
+	base = encodeEntities(t, &Metadata{ReadTime: &readTime}, entity(1, "a"))
+	inc1 = encodeEntities(t, nil, entity(1, "a1"))
+	out.Reset()
+	require.NoError(t, MergeIncremental(base, []io.Reader{inc1}, &out))
+	meta, r, err = ReadMetadata(&out)
+	require.NoError(t, err)
+	assert.Nil(t, meta)
+	assert.Equal(t, []Entity{entity(1, "a1")}, decodeEntities(t, r))
+
+	partial := encodeEntities(t, &Metadata{Partial: true}, entity(1, "a"))
+	assert.Error(t, MergeIncremental(bytes.NewReader(nil), []io.Reader{partial}, &out))
+}
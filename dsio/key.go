@@ -1,6 +1,7 @@
 package dsio
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -8,6 +9,7 @@ import (
 	"cloud.google.com/go/datastore"
 )
 
+// MarshalKey returns the textual representation of a key, e.g. "/Parent,name/Child,123".
 func MarshalKey(key *datastore.Key) string {
 	var s string
 	if key.Name == "" {
@@ -41,13 +43,49 @@ func escapeKeyPart(s string) string {
 	return strings.Replace(strings.Replace(strings.Replace(strings.Replace(s, `^`, `^^`, -1), `,`, `^,`, -1), `/`, `^/`, -1), "`", "^`", -1)
 }
 
-func UnmarshalKey(s string) (key *datastore.Key) {
+// UnmarshalKey parses a key in the format produced by MarshalKey, ignoring errors.
+// Use ParseKey to validate the input.
+func UnmarshalKey(s string) *datastore.Key {
+	key, _ := ParseKey(s)
+	return key
+}
+
+// ParseKey parses a key in the format produced by MarshalKey.
+// The returned error includes the position of the offending character.
+func ParseKey(s string) (key *datastore.Key, err error) {
+	if s == "" {
+		return nil, errors.New("Invalid key: empty string")
+	}
 	b := []byte(s)
 	quote := false
-	mode := 0
+	mode := 0 // 0: start, 1: kind, 2: id or name, 3: namespace
 	var buf []byte
 	kind, value := "", ""
+	valuePos := 0
 	inttype := true
+	appendKey := func() error {
+		if mode == 2 {
+			value = string(buf)
+		}
+		switch {
+		case inttype && value == "":
+			return keyError(s, valuePos, "missing ID or name")
+		case inttype:
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return keyError(s, valuePos, fmt.Sprintf("invalid ID %q", value))
+			}
+			key = datastore.IDKey(kind, id, key)
+		case value == "":
+			return keyError(s, valuePos, "empty name")
+		default:
+			key = datastore.NameKey(kind, value, key)
+		}
+		if mode == 3 {
+			key.Namespace = string(buf)
+		}
+		return nil
+	}
 	for i, n := 0, len(b); i < n; i++ {
 		if quote {
 			quote = false
@@ -57,28 +95,27 @@ func UnmarshalKey(s string) (key *datastore.Key) {
 				quote = true
 				continue
 			case '/':
-				if mode > 1 {
-					if mode == 2 {
-						value = string(buf)
-					}
-					if inttype {
-						id, _ := strconv.ParseInt(value, 10, 64)
-						key = datastore.IDKey(kind, id, key)
-					} else {
-						key = datastore.NameKey(kind, value, key)
+				switch mode {
+				case 0:
+				case 1:
+					return nil, keyError(s, i, "expected ','")
+				default:
+					if err = appendKey(); err != nil {
+						return nil, err
 					}
-					if mode == 3 {
-						key.Namespace = string(buf)
-					}
-					kind, value, buf, inttype = "", "", nil, true
 				}
+				kind, value, buf, inttype = "", "", nil, true
 				mode = 1
 				continue
 			case ',':
 				if mode == 1 {
+					if len(buf) == 0 {
+						return nil, keyError(s, i, "empty kind")
+					}
 					kind = string(buf)
 					buf = nil
 					mode = 2
+					valuePos = i + 1
 					continue
 				}
 			case '`':
@@ -94,24 +131,156 @@ func UnmarshalKey(s string) (key *datastore.Key) {
 				}
 			}
 		}
+		if mode == 0 {
+			return nil, keyError(s, i, "expected '/'")
+		}
 		buf = append(buf, b[i])
 		if mode == 2 && inttype && (b[i] < '0' || b[i] > '9') {
 			inttype = false
 		}
 	}
-	if mode > 1 {
-		if mode == 2 {
-			value = string(buf)
+	switch {
+	case quote:
+		return nil, keyError(s, len(b), "unterminated escape")
+	case mode == 1:
+		return nil, keyError(s, len(b), "expected ','")
+	case mode > 1:
+		if err = appendKey(); err != nil {
+			return nil, err
 		}
-		if inttype {
-			id, _ := strconv.ParseInt(value, 10, 64)
-			key = datastore.IDKey(kind, id, key)
-		} else {
-			key = datastore.NameKey(kind, value, key)
+	}
+	return key, nil
+}
+
+func keyError(s string, pos int, msg string) error {
+	return fmt.Errorf("Invalid key %q at position %d: %s", s, pos, msg)
+}
+
+// FormatGQLKey returns the GQL KEY() literal of a key, e.g. "KEY(Parent, 'name', Child, 123)".
+func FormatGQLKey(key *datastore.Key) string {
+	var path []string
+	for k := key; k != nil; k = k.Parent {
+		v := strconv.FormatInt(k.ID, 10)
+		if k.Name != "" {
+			v = quoteGQL(k.Name)
 		}
-		if mode == 3 {
-			key.Namespace = string(buf)
+		path = append([]string{quoteGQLName(k.Kind), v}, path...)
+	}
+	if key.Namespace != "" {
+		path = append([]string{"NAMESPACE(" + quoteGQL(key.Namespace) + ")"}, path...)
+	}
+	return "KEY(" + strings.Join(path, ", ") + ")"
+}
+
+// ParseGQLKey parses a GQL KEY() literal, e.g. "KEY(NAMESPACE('ns'), Parent, 'name', Child, 123)".
+// A PROJECT() argument is accepted and ignored.
+func ParseGQLKey(s string) (*datastore.Key, error) {
+	l := &lexer{s: s}
+	key, err := l.parseKeyLiteral()
+	if err != nil {
+		return nil, err
+	}
+	if t, err := l.next(); err != nil {
+		return nil, err
+	} else if t.kind != tokEOF {
+		return nil, l.errorf(t.pos, "unexpected %q after key", t.text)
+	}
+	return key, nil
+}
+
+// parseKeyLiteral parses a KEY(...) literal starting at the current position.
+func (l *lexer) parseKeyLiteral() (key *datastore.Key, err error) {
+	expect := func(text string) error {
+		t, err := l.next()
+		if err == nil && (t.kind != tokOp || t.text != text) {
+			err = l.errorf(t.pos, "expected %q", text)
 		}
+		return err
+	}
+	t, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != tokIdent || !strings.EqualFold(t.text, "KEY") {
+		return nil, l.errorf(t.pos, "expected KEY")
+	}
+	if err = expect("("); err != nil {
+		return nil, err
+	}
+	namespace := ""
+	for first := true; ; first = false {
+		t, err = l.next()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == tokOp && t.text == ")" && key != nil {
+			return key, nil
+		}
+		if !first {
+			if t.kind != tokOp || t.text != "," {
+				return nil, l.errorf(t.pos, "expected ',' or ')'")
+			}
+			if t, err = l.next(); err != nil {
+				return nil, err
+			}
+		}
+		if t.kind != tokIdent {
+			return nil, l.errorf(t.pos, "expected kind")
+		}
+		if p, _ := l.peek(); key == nil && p.kind == tokOp && p.text == "(" &&
+			(strings.EqualFold(t.text, "PROJECT") || strings.EqualFold(t.text, "NAMESPACE")) {
+			l.next()
+			v, err := l.next()
+			if err != nil {
+				return nil, err
+			}
+			if v.kind != tokString {
+				return nil, l.errorf(v.pos, "expected string")
+			}
+			if strings.EqualFold(t.text, "NAMESPACE") {
+				namespace = v.text
+			}
+			if err = expect(")"); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		kind := t.text
+		if err = expect(","); err != nil {
+			return nil, err
+		}
+		if t, err = l.next(); err != nil {
+			return nil, err
+		}
+		switch t.kind {
+		case tokInt:
+			id, err := strconv.ParseInt(t.text, 10, 64)
+			if err != nil {
+				return nil, l.errorf(t.pos, "invalid ID %q", t.text)
+			}
+			key = datastore.IDKey(kind, id, key)
+		case tokString:
+			key = datastore.NameKey(kind, t.text, key)
+		default:
+			return nil, l.errorf(t.pos, "expected ID or name")
+		}
+		key.Namespace = namespace
+	}
+}
+
+// ParseAnyKey parses a key in any supported notation:
+// MarshalKey format ("/Kind,123"), GQL literal ("KEY(Kind, 123)") or URL-safe encoded (datastore.Key.Encode).
+func ParseAnyKey(s string) (*datastore.Key, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "/"):
+		return ParseKey(s)
+	case len(s) > 3 && strings.EqualFold(s[:3], "KEY") && strings.HasPrefix(strings.TrimSpace(s[3:]), "("):
+		return ParseGQLKey(s)
+	}
+	key, err := datastore.DecodeKey(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid key %q: %v", s, err)
 	}
-	return
+	return key, nil
 }
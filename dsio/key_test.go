@@ -50,3 +50,55 @@ func TestMarshalKey_NS(t *testing.T) {
 	require.Equal(t, "/A,0`ns1^`/B,^`2^``ns2^^", enc)
 	require.Equal(t, key, UnmarshalKey(enc))
 }
+
+func TestParseKey_errors(t *testing.T) {
+	for _, tc := range []struct{ in, err string }{
+		{"", `Invalid key: empty string`},
+		{"A,1", `Invalid key "A,1" at position 0: expected '/'`},
+		{"/A", `Invalid key "/A" at position 2: expected ','`},
+		{"/A/B,1", `Invalid key "/A/B,1" at position 2: expected ','`},
+		{"/,1", `Invalid key "/,1" at position 1: empty kind`},
+		{"/A,", `Invalid key "/A," at position 3: missing ID or name`},
+		{"/A,`", `Invalid key "/A,` + "`" + `" at position 3: empty name`},
+		{"/A,1/B,99999999999999999999", `Invalid key "/A,1/B,99999999999999999999" at position 7: invalid ID "99999999999999999999"`},
+		{"/A,x^", `Invalid key "/A,x^" at position 5: unterminated escape`},
+	} {
+		key, err := ParseKey(tc.in)
+		require.EqualError(t, err, tc.err)
+		require.Nil(t, key)
+	}
+	key, err := ParseKey("/A,0`ns1^`/B,^`2^``ns2^^")
+	require.NoError(t, err)
+	require.Equal(t, "ns2^", key.Namespace)
+}
+
+func TestGQLKey(t *testing.T) {
+	key := datastore.NameKey("B", "it's", datastore.IDKey("A", 5, nil))
+	key.Namespace, key.Parent.Namespace = "ns", "ns"
+	gql := FormatGQLKey(key)
+	require.Equal(t, `KEY(NAMESPACE('ns'), A, 5, B, 'it\'s')`, gql)
+	parsed, err := ParseGQLKey(gql)
+	require.NoError(t, err)
+	require.Equal(t, key, parsed)
+	parsed, err = ParseGQLKey(`key(PROJECT("p"), ` + "`My Kind`" + `, "x")`)
+	require.NoError(t, err)
+	require.Equal(t, datastore.NameKey("My Kind", "x", nil), parsed)
+	require.Equal(t, "KEY(`My Kind`, 'x')", FormatGQLKey(parsed))
+	_, err = ParseGQLKey(`KEY(A, 1, B)`)
+	require.EqualError(t, err, `Syntax error at position 11: expected ","`)
+	_, err = ParseGQLKey(`KEY(A, 1.5)`)
+	require.EqualError(t, err, `Syntax error at position 7: expected ID or name`)
+	_, err = ParseGQLKey(`KEY(A, 'x`)
+	require.EqualError(t, err, `Syntax error at position 7: unterminated string`)
+}
+
+func TestParseAnyKey(t *testing.T) {
+	key := datastore.NameKey("B", "x", datastore.IDKey("A", 5, nil))
+	for _, s := range []string{MarshalKey(key), " " + FormatGQLKey(key), key.Encode()} {
+		parsed, err := ParseAnyKey(s)
+		require.NoError(t, err, s)
+		require.Equal(t, key, parsed, s)
+	}
+	_, err := ParseAnyKey("garbage")
+	require.Error(t, err)
+}
@@ -0,0 +1,84 @@
+package dsio
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// ReadKeys reads a list of keys, one per line, in any notation supported by ParseAnyKey.
+// Empty lines and lines starting with '#' are skipped.
+func ReadKeys(r io.Reader) (keys []*datastore.Key, err error) {
+	rbuf := bufio.NewScanner(r)
+	rbuf.Buffer(make([]byte, 32768), 1024*1024)
+	linenr := 0
+	for rbuf.Scan() {
+		linenr++
+		s := strings.TrimSpace(rbuf.Text())
+		if s == "" || s[0] == '#' {
+			continue
+		}
+		key, err := ParseAnyKey(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", linenr, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rbuf.Err()
+}
+
+// GetEntities fetches the entities with the given keys (at most 1000) using a single GetMulti call.
+// Returns the found entities and the keys that were not found.
+func GetEntities(ds *datastore.Client, keys []*datastore.Key) (found []Entity, missing []*datastore.Key, err error) {
+	rows := make([]datastore.PropertyList, len(keys))
+	err = ds.GetMulti(context.Background(), keys, rows)
+	var merr datastore.MultiError
+	if err != nil && !errors.As(err, &merr) {
+		return nil, nil, err
+	}
+	for i, key := range keys {
+		if merr != nil && merr[i] != nil {
+			if merr[i] != datastore.ErrNoSuchEntity {
+				return nil, nil, merr[i]
+			}
+			missing = append(missing, key)
+			continue
+		}
+		found = append(found, Entity{Key: key, Properties: rows[i]})
+	}
+	return found, missing, nil
+}
+
+// ExportKeys exports the entities with the given keys into the given stream, in the same format as ExportQuery.
+// Returns the keys that were not found.
+func ExportKeys(ds *datastore.Client, keys []*datastore.Key, w io.Writer) (missing []*datastore.Key, err error) {
+	var found []Entity
+	batchSize := 200
+	src := func() (Entity, error) {
+		for len(found) == 0 {
+			if len(keys) == 0 {
+				return Entity{}, iterator.Done
+			}
+			n := min(batchSize, len(keys))
+			var notfound []*datastore.Key
+			var err error
+			found, notfound, err = GetEntities(ds, keys[:n])
+			if err != nil {
+				return Entity{}, err
+			}
+			missing = append(missing, notfound...)
+			keys = keys[n:]
+		}
+		rec := found[0]
+		found = found[1:]
+		return rec, nil
+	}
+	err = exportEntities(src, w, nil)
+	return missing, err
+}
@@ -0,0 +1,17 @@
+package dsio
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadKeys(t *testing.T) {
+	keys, err := ReadKeys(strings.NewReader("# incident 123\n/A,1\n\n  KEY(A, 'x')  \n" + datastore.IDKey("B", 2, nil).Encode() + "\n"))
+	require.NoError(t, err)
+	require.Equal(t, []*datastore.Key{datastore.IDKey("A", 1, nil), datastore.NameKey("A", "x", nil), datastore.IDKey("B", 2, nil)}, keys)
+	_, err = ReadKeys(strings.NewReader("/A,1\n/A,\n"))
+	require.EqualError(t, err, `line 2: Invalid key "/A," at position 3: missing ID or name`)
+}
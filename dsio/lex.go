@@ -0,0 +1,164 @@
+package dsio
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind is the kind of a lexical token of GQL-like expressions.
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokIdent            // name or `quoted name`
+	tokString           // 'string' or "string"
+	tokInt              // 123
+	tokFloat            // 1.5, 1e3
+	tokOp               // ( ) , = != < <= > >= : @ * and other punctuation
+)
+
+type token struct {
+	kind tokenKind
+	text string // decoded text (unquoted for strings and quoted names)
+	pos  int    // byte offset in the input
+}
+
+// lexer splits GQL-like expressions into tokens.
+type lexer struct {
+	s   string
+	pos int
+}
+
+func (l *lexer) errorf(pos int, format string, args ...any) error {
+	return fmt.Errorf("Syntax error at position %d: %s", pos, fmt.Sprintf(format, args...))
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// next returns the next token, or a tokEOF token at the end of input.
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.s) && strings.IndexByte(" \t\r\n", l.s[l.pos]) >= 0 {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.s) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.s[l.pos]
+	switch {
+	case c == '\'' || c == '"' || c == '`':
+		text, err := l.quoted(c)
+		if err != nil {
+			return token{}, err
+		}
+		if c == '`' {
+			return token{kind: tokIdent, text: text, pos: start}, nil
+		}
+		return token{kind: tokString, text: text, pos: start}, nil
+	case isDigit(c) || c == '.' && l.pos+1 < len(l.s) && isDigit(l.s[l.pos+1]):
+		kind := tokInt
+		for l.pos < len(l.s) && isDigit(l.s[l.pos]) {
+			l.pos++
+		}
+		if l.pos < len(l.s) && l.s[l.pos] == '.' {
+			kind = tokFloat
+			l.pos++
+			for l.pos < len(l.s) && isDigit(l.s[l.pos]) {
+				l.pos++
+			}
+		}
+		if l.pos < len(l.s) && (l.s[l.pos] == 'e' || l.s[l.pos] == 'E') {
+			kind = tokFloat
+			l.pos++
+			if l.pos < len(l.s) && (l.s[l.pos] == '+' || l.s[l.pos] == '-') {
+				l.pos++
+			}
+			for l.pos < len(l.s) && isDigit(l.s[l.pos]) {
+				l.pos++
+			}
+		}
+		return token{kind: kind, text: l.s[start:l.pos], pos: start}, nil
+	case isIdentChar(c):
+		for l.pos < len(l.s) && isIdentChar(l.s[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.s[start:l.pos], pos: start}, nil
+	case strings.HasPrefix(l.s[l.pos:], "!=") || strings.HasPrefix(l.s[l.pos:], "<=") || strings.HasPrefix(l.s[l.pos:], ">="):
+		l.pos += 2
+		return token{kind: tokOp, text: l.s[start:l.pos], pos: start}, nil
+	}
+	l.pos++
+	return token{kind: tokOp, text: l.s[start:l.pos], pos: start}, nil
+}
+
+// peek returns the next token without consuming it.
+func (l *lexer) peek() (token, error) {
+	pos := l.pos
+	t, err := l.next()
+	l.pos = pos
+	return t, err
+}
+
+// quoted reads a quoted string starting at the current position.
+// The quote character can be escaped by doubling it or with a backslash.
+func (l *lexer) quoted(q byte) (string, error) {
+	start := l.pos
+	l.pos++
+	var sb strings.Builder
+	for l.pos < len(l.s) {
+		c := l.s[l.pos]
+		l.pos++
+		switch {
+		case c == q && l.pos < len(l.s) && l.s[l.pos] == q:
+			sb.WriteByte(q)
+			l.pos++
+		case c == q:
+			return sb.String(), nil
+		case c == '\\' && l.pos < len(l.s):
+			e := l.s[l.pos]
+			l.pos++
+			switch e {
+			case '0':
+				sb.WriteByte(0)
+			case 'b':
+				sb.WriteByte('\b')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'Z':
+				sb.WriteByte(0x1a)
+			default:
+				sb.WriteByte(e)
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", l.errorf(start, "unterminated string")
+}
+
+// quoteGQL returns s as a single-quoted GQL string literal.
+func quoteGQL(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// quoteGQLName returns s as a GQL name, backquoted if needed.
+func quoteGQLName(s string) string {
+	simple := s != "" && !isDigit(s[0])
+	for i := 0; i < len(s) && simple; i++ {
+		simple = isIdentChar(s[i]) && s[i] < 0x80
+	}
+	if simple {
+		return s
+	}
+	return "`" + strings.ReplaceAll(s, "`", "``") + "`"
+}
@@ -0,0 +1,57 @@
+package dsio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Metadata describes an export stream. It is written as the first line of the stream
+// and is skipped by Unmarshal.
+type Metadata struct {
+	// Partial is set if only a subset of the entity properties was exported.
+	Partial bool `json:"partial,omitempty"`
+	// Fields lists the exported properties of a partial export.
+	Fields []string `json:"fields,omitempty"`
+	// ReadTime is the snapshot time of a point-in-time export.
+	ReadTime *time.Time `json:"readTime,omitempty"`
+	// Patch is set for a patch written by diff, whose entities are changes (see PatchEntity).
+	Patch bool `json:"patch,omitempty"`
+}
+
+type jsonMeta struct {
+	Meta *Metadata `json:"Meta"`
+}
+
+var metaPrefix = []byte(`{"Meta":`)
+
+func writeMetadata(w io.Writer, meta *Metadata) error {
+	b, err := json.Marshal(jsonMeta{meta})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// ReadMetadata reads the metadata of an export stream, if present.
+// Returns nil metadata for streams without it, and a reader to be used instead of r for further reading.
+func ReadMetadata(r io.Reader) (*Metadata, io.Reader, error) {
+	rbuf := bufio.NewReaderSize(r, 32768)
+	b, err := rbuf.Peek(len(metaPrefix))
+	if err != nil || !bytes.Equal(b, metaPrefix) {
+		return nil, rbuf, nil // let the caller deal with any read error
+	}
+	line, err := rbuf.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	m := jsonMeta{}
+	if err = json.Unmarshal(line, &m); err != nil {
+		return nil, nil, fmt.Errorf("line 1 JSON Unmarshal error: %v", err)
+	}
+	return m.Meta, io.MultiReader(bytes.NewReader(line), rbuf), nil
+}
@@ -0,0 +1,69 @@
+package dsio
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/iterator"
+)
+
+func TestMetadata(t *testing.T) {
+	rows := []Entity{
+		{Key: datastore.IDKey("Test", 1, nil), Properties: datastore.PropertyList{{Name: "A", Value: "a"}}},
+	}
+	var buf bytes.Buffer
+	next := func() (Entity, error) {
+		if len(rows) == 0 {
+			return Entity{}, iterator.Done
+		}
+		rec := rows[0]
+		rows = rows[1:]
+		return rec, nil
+	}
+	require.NoError(t, exportEntities(next, &buf, &Metadata{Partial: true, Fields: []string{"A"}}))
+	assert.True(t, strings.HasPrefix(buf.String(), `{"Meta":{"partial":true,"fields":["A"]}}`+"\n"))
+
+	meta, r, err := ReadMetadata(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, &Metadata{Partial: true, Fields: []string{"A"}}, meta)
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, buf.String(), string(b))
+
+	// the metadata line is skipped on import
+	outCh := make(chan Entity, 10)
+	errCh := make(chan error)
+	go func() {
+		defer close(errCh)
+		var res []Entity
+		for rec := range outCh {
+			res = append(res, rec)
+		}
+		assert.Len(t, res, 1)
+	}()
+	require.NoError(t, ImportFile(bytes.NewReader(buf.Bytes()), outCh, errCh))
+
+	meta, r, err = ReadMetadata(strings.NewReader(`{"k":"/Test,1","d":[]}`))
+	require.NoError(t, err)
+	assert.Nil(t, meta)
+	b, err = io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, `{"k":"/Test,1","d":[]}`, string(b))
+}
+
+func TestMetadataReadTime(t *testing.T) {
+	readTime := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	require.NoError(t, writeMetadata(&buf, &Metadata{ReadTime: &readTime}))
+	assert.Equal(t, `{"Meta":{"readTime":"2024-05-01T12:30:00Z"}}`+"\n", buf.String())
+	meta, _, err := ReadMetadata(&buf)
+	require.NoError(t, err)
+	assert.False(t, meta.Partial)
+	assert.Equal(t, readTime, *meta.ReadTime)
+}
@@ -0,0 +1,278 @@
+package dsio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// The NDJSON format is a self-describing alternative to the export format, with one entity per line:
+//
+//	{"key":{"path":[{"kind":"User","name":"ann"},{"kind":"Event","id":1}]},"properties":{"at":{"time":"2024-05-01T10:30:00Z"},"tags":{"array":[{"string":"a"}]},"body":{"string":"x","noindex":true}}}
+//
+// Each property value is an object with a single type field (string, int, float, bool, time, key, blob, array or null)
+// and an optional noindex field. Non-finite floats are written as strings ("NaN", "+Inf", "-Inf"),
+// timestamps in RFC 3339 format, keys in dsutil notation and blobs in base64.
+// NDJSON lines are read by Unmarshal, so NDJSON files can be imported like export files.
+// Repeated properties (several values with the same name) are written as a single array value,
+// so they become one multi-valued property when read back.
+
+type ndjsonEntity struct {
+	Key        *ndjsonKey             `json:"key"`
+	Properties map[string]ndjsonValue `json:"properties"`
+}
+
+type ndjsonKey struct {
+	Namespace string          `json:"namespace,omitempty"`
+	Path      []ndjsonKeyPart `json:"path"`
+}
+
+type ndjsonKeyPart struct {
+	Kind string `json:"kind"`
+	ID   int64  `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type ndjsonValue struct { // implements json.Marshaler and json.Unmarshaler
+	value   any
+	noIndex bool
+}
+
+// MarshalNDJSON returns an entity in NDJSON format, without a trailing newline.
+func MarshalNDJSON(rec Entity) ([]byte, error) {
+	e := ndjsonEntity{Key: &ndjsonKey{Namespace: rec.Key.Namespace}, Properties: make(map[string]ndjsonValue)}
+	for k := rec.Key; k != nil; k = k.Parent {
+		e.Key.Path = append([]ndjsonKeyPart{{Kind: k.Kind, ID: k.ID, Name: k.Name}}, e.Key.Path...)
+	}
+	for _, p := range rec.Properties {
+		v, ok := e.Properties[p.Name]
+		switch {
+		case !ok:
+			v = ndjsonValue{value: p.Value, noIndex: p.NoIndex}
+		case isArray(v.value):
+			v.value = append(v.value.([]any), p.Value)
+		default:
+			v.value = []any{v.value, p.Value}
+		}
+		e.Properties[p.Name] = v
+	}
+	return json.Marshal(e)
+}
+
+func isArray(value any) bool {
+	_, ok := value.([]any)
+	return ok
+}
+
+// UnmarshalNDJSON parses an entity in NDJSON format. Null values are skipped.
+func UnmarshalNDJSON(b []byte) (Entity, error) {
+	var e ndjsonEntity
+	if err := json.Unmarshal(b, &e); err != nil {
+		return Entity{}, err
+	}
+	return e.entity()
+}
+
+func (e *ndjsonEntity) entity() (rec Entity, err error) {
+	if e.Key == nil || len(e.Key.Path) == 0 {
+		return rec, errors.New("Missing key path")
+	}
+	for _, p := range e.Key.Path {
+		switch {
+		case p.Kind == "":
+			return rec, errors.New("Missing key kind")
+		case p.Name != "":
+			rec.Key = datastore.NameKey(p.Kind, p.Name, rec.Key)
+		default:
+			rec.Key = datastore.IDKey(p.Kind, p.ID, rec.Key)
+		}
+		rec.Key.Namespace = e.Key.Namespace
+	}
+	rec.Properties = make(datastore.PropertyList, 0, len(e.Properties))
+	for name, v := range e.Properties {
+		if v.value != nil {
+			rec.Properties = append(rec.Properties, datastore.Property{Name: name, Value: v.value, NoIndex: v.noIndex})
+		}
+	}
+	sort.Slice(rec.Properties, func(a, b int) bool {
+		return rec.Properties[a].Name < rec.Properties[b].Name
+	})
+	return rec, nil
+}
+
+func (v ndjsonValue) MarshalJSON() ([]byte, error) {
+	typ := valueTypeName(v.value)
+	var value any
+	switch x := v.value.(type) {
+	case nil, string, int64, bool:
+		value = x
+	case float64:
+		value = x
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			value = strconv.FormatFloat(x, 'g', -1, 64)
+		}
+	case time.Time:
+		value = x.UTC().Format(time.RFC3339Nano)
+	case *datastore.Key:
+		value = MarshalKey(x)
+	case []byte:
+		value = base64.StdEncoding.EncodeToString(x)
+	case []any:
+		arr := make([]ndjsonValue, len(x))
+		for i, e := range x {
+			arr[i] = ndjsonValue{value: e}
+		}
+		value = arr
+	default:
+		return nil, fmt.Errorf("Unsupported data type '%T'", v.value)
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"%s":%s`, typ, b)
+	if v.noIndex {
+		buf.WriteString(`,"noindex":true`)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (v *ndjsonValue) UnmarshalJSON(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if raw, ok := fields["noindex"]; ok {
+		if err := json.Unmarshal(raw, &v.noIndex); err != nil {
+			return fmt.Errorf("Invalid noindex value %s", raw)
+		}
+		delete(fields, "noindex")
+	}
+	if len(fields) != 1 {
+		return fmt.Errorf("Expected a single type in value %s", b)
+	}
+	for typ, raw := range fields {
+		var err error
+		if v.value, err = unmarshalNDJSONValue(typ, raw); err != nil {
+			return fmt.Errorf("Unable to unmarshal '%s' as %s", raw, typ)
+		}
+	}
+	return nil
+}
+
+func unmarshalNDJSONValue(typ string, raw json.RawMessage) (any, error) {
+	if string(raw) == "null" {
+		return nil, nil
+	}
+	switch typ {
+	case "int", "float":
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err != nil {
+			var s string // non-finite floats
+			if err = json.Unmarshal(raw, &s); err != nil {
+				return nil, err
+			}
+			n = json.Number(s)
+		}
+		if typ == "int" {
+			return strconv.ParseInt(n.String(), 10, 64)
+		}
+		return strconv.ParseFloat(n.String(), 64)
+	case "bool":
+		var x bool
+		err := json.Unmarshal(raw, &x)
+		return x, err
+	case "array":
+		var arr []ndjsonValue
+		if err := json.Unmarshal(raw, &arr); err != nil {
+			return nil, err
+		}
+		res := make([]any, len(arr))
+		for i, e := range arr {
+			res[i] = e.value
+		}
+		return res, nil
+	case "string", "time", "key", "blob":
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		return parseValueAs(typ, s)
+	}
+	return nil, fmt.Errorf("Unsupported data type '%s'", typ)
+}
+
+// WriteNDJSON converts an export stream (or an NDJSON stream) to NDJSON, keeping its metadata.
+func WriteNDJSON(w io.Writer, r io.Reader) error {
+	meta, r, err := ReadMetadata(r)
+	if err != nil {
+		return err
+	}
+	wbuf := bufio.NewWriterSize(w, 32768)
+	if meta != nil {
+		if err = writeMetadata(wbuf, meta); err != nil {
+			return err
+		}
+	}
+	err = readEntities(r, func(rec Entity) error {
+		b, err := MarshalNDJSON(rec)
+		if err != nil {
+			return fmt.Errorf("%s: %v", MarshalKey(rec.Key), err)
+		}
+		wbuf.Write(b)
+		return wbuf.WriteByte('\n')
+	})
+	if err != nil {
+		return err
+	}
+	return wbuf.Flush()
+}
+
+type ndjsonWriter struct {
+	pw   *io.PipeWriter
+	w    io.WriteCloser
+	done chan error
+	once sync.Once
+	err  error
+}
+
+// NewNDJSONWriter returns a writer converting the export stream written to it (e.g. by ExportQuery) to NDJSON into w.
+// Close must be called to complete the conversion; it also closes w.
+func NewNDJSONWriter(w io.WriteCloser) io.WriteCloser {
+	pr, pw := io.Pipe()
+	nw := &ndjsonWriter{pw: pw, w: w, done: make(chan error, 1)}
+	go func() {
+		err := WriteNDJSON(w, pr)
+		pr.CloseWithError(err) // unblock the export on error
+		nw.done <- err
+	}()
+	return nw
+}
+
+func (nw *ndjsonWriter) Write(b []byte) (int, error) {
+	return nw.pw.Write(b)
+}
+
+func (nw *ndjsonWriter) Close() error {
+	nw.once.Do(func() {
+		nw.pw.Close()
+		nw.err = <-nw.done
+		if err := nw.w.Close(); nw.err == nil {
+			nw.err = err
+		}
+	})
+	return nw.err
+}
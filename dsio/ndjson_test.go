@@ -0,0 +1,84 @@
+package dsio
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestNDJSON(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 10, 30, 0, 123456000, time.UTC)
+	key := datastore.IDKey("Event", 1, datastore.NameKey("User", "ann", nil))
+	rec := Entity{Key: key, Properties: datastore.PropertyList{
+		{Name: "at", Value: ts},
+		{Name: "body", Value: "x", NoIndex: true},
+		{Name: "data", Value: []byte("hi")},
+		{Name: "nan", Value: math.NaN()},
+		{Name: "owner", Value: datastore.NameKey("User", "bob", nil)},
+		{Name: "tags", Value: []any{"a", int64(2), nil}},
+		{Name: "v", Value: int64(1) << 60},
+	}}
+	b, err := MarshalNDJSON(rec)
+	require.NoError(t, err)
+	assert.Equal(t, `{"key":{"path":[{"kind":"User","name":"ann"},{"kind":"Event","id":1}]},"properties":{`+
+		`"at":{"time":"2024-05-01T10:30:00.123456Z"},"body":{"string":"x","noindex":true},"data":{"blob":"aGk="},"nan":{"float":"NaN"},`+
+		`"owner":{"key":"/User,bob"},"tags":{"array":[{"string":"a"},{"int":2},{"null":null}]},"v":{"int":1152921504606846976}}}`, string(b))
+
+	res := decodeEntities(t, bytes.NewReader(append(b, '\n')))
+	require.Len(t, res, 1)
+	assert.Equal(t, key, res[0].Key)
+	assert.True(t, math.IsNaN(res[0].Properties[3].Value.(float64)))
+	res[0].Properties[3].Value = rec.Properties[3].Value
+	assert.Equal(t, rec.Properties[:3], res[0].Properties[:3])
+	assert.Equal(t, rec.Properties[4:], res[0].Properties[4:])
+
+	_, err = UnmarshalNDJSON([]byte(`{"key":{"path":[{"kind":"K","id":1}]},"properties":{"a":{"int":1,"string":"x"}}}`))
+	assert.EqualError(t, err, `Expected a single type in value {"int":1,"string":"x"}`)
+	_, err = UnmarshalNDJSON([]byte(`{"key":{"path":[{"kind":"K","id":1}]},"properties":{"a":{"int":"x"}}}`))
+	assert.EqualError(t, err, `Unable to unmarshal '"x"' as int`)
+	_, err = UnmarshalNDJSON([]byte(`{"key":{"path":[]},"properties":{}}`))
+	assert.EqualError(t, err, "Missing key path")
+}
+
+func TestNewNDJSONWriter(t *testing.T) {
+	readTime := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	in := encodeEntities(t, &Metadata{ReadTime: &readTime},
+		Entity{Key: datastore.NameKey("K", "a", nil), Properties: datastore.PropertyList{{Name: "n", Value: 1.5}}},
+		Entity{Key: &datastore.Key{Kind: "K", ID: 2, Namespace: "ns"}, Properties: datastore.PropertyList{{Name: "n", Value: 2.0}}},
+	)
+	var out bytes.Buffer
+	w := NewNDJSONWriter(nopWriteCloser{&out})
+	_, err := io.Copy(w, in)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, `{"Meta":{"readTime":"2024-05-01T00:00:00Z"}}
+{"key":{"path":[{"kind":"K","name":"a"}]},"properties":{"n":{"float":1.5}}}
+{"key":{"namespace":"ns","path":[{"kind":"K","id":2}]},"properties":{"n":{"float":2}}}
+`, out.String())
+
+	meta, r, err := ReadMetadata(&out)
+	require.NoError(t, err)
+	assert.Equal(t, &readTime, meta.ReadTime)
+	res := decodeEntities(t, r)
+	require.Len(t, res, 2)
+	assert.Equal(t, "ns", res[1].Key.Namespace)
+	assert.Equal(t, datastore.PropertyList{{Name: "n", Value: 2.0}}, res[1].Properties)
+
+	w = NewNDJSONWriter(nopWriteCloser{&out})
+	_, err = io.Copy(w, strings.NewReader("{\"k\":\"/K,1\",\"d\":[1]}\n"))
+	require.NoError(t, err)
+	assert.Error(t, w.Close())
+}
@@ -0,0 +1,499 @@
+package dsio
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// ParquetOptions controls the Parquet output.
+type ParquetOptions struct {
+	// RowGroupSize is the number of rows per row group, 100000 if zero.
+	RowGroupSize int
+	// Uncompressed disables the gzip compression of the pages.
+	Uncompressed bool
+}
+
+// Parquet physical types, repetition types, converted types and codecs, as defined by parquet.thrift.
+const (
+	pqBoolean   = 0
+	pqInt64     = 2
+	pqDouble    = 5
+	pqByteArray = 6
+
+	pqRequired = 0
+	pqOptional = 1
+	pqRepeated = 2
+
+	pqUTF8            = 0
+	pqTimestampMicros = 10
+
+	pqPlain = 0
+	pqRLE   = 3
+
+	pqUncompressed = 0
+	pqGzip         = 2
+)
+
+type pqNode struct {
+	name     string
+	children []*pqNode // nil for columns
+	column   *pqColumn
+}
+
+type pqColumn struct {
+	path     []string
+	typ      string // the value type name (see valueTypeName), or "any" for typed literals
+	repeated bool
+	required bool
+	groups   []string // property name prefixes of the enclosing groups, e.g. "a.", "a.b."
+	values   func(rec Entity, props map[string][]any) []any
+	maxDef   int
+	maxRep   int
+	// current row group
+	defs, reps []int
+	data       bytes.Buffer
+	bools      []bool
+}
+
+// WriteParquet writes the entities of the given kind (as inferred by ReadSchema) from the export stream r
+// as a Parquet file, skipping the entities of other kinds.
+// Besides a column (or a group, for embedded entities) per property, the file has a required "__key__" column
+// in dsutil notation and "__namespace__", "__parent__", "__id__" and "__name__" key component columns.
+// Repeated properties are repeated columns; null array elements are skipped.
+// Timestamps are stored in microseconds, keys in dsutil notation and mixed-type properties as typed literals,
+// except int and float which are stored as doubles.
+func WriteParquet(w io.Writer, ks *KindSchema, r io.Reader, opts ParquetOptions) error {
+	if opts.RowGroupSize <= 0 {
+		opts.RowGroupSize = 100000
+	}
+	root := &pqNode{name: "schema"}
+	var columns []*pqColumn
+	addKey := func(name, typ string, required bool, value func(key *datastore.Key) any) {
+		c := &pqColumn{path: []string{name}, typ: typ, required: required, values: func(rec Entity, _ map[string][]any) []any {
+			if v := value(rec.Key); v != nil {
+				return []any{v}
+			}
+			return nil
+		}}
+		root.children = append(root.children, &pqNode{name: name, column: c})
+		columns = append(columns, c)
+	}
+	addKey("__key__", "string", true, func(key *datastore.Key) any { return MarshalKey(key) })
+	addKey("__namespace__", "string", false, func(key *datastore.Key) any { return nonEmpty(key.Namespace) })
+	addKey("__parent__", "key", false, func(key *datastore.Key) any {
+		if key.Parent == nil {
+			return nil
+		}
+		return key.Parent
+	})
+	addKey("__id__", "int", false, func(key *datastore.Key) any {
+		if key.Name != "" {
+			return nil
+		}
+		return key.ID
+	})
+	addKey("__name__", "string", false, func(key *datastore.Key) any { return nonEmpty(key.Name) })
+	columns = pqAddProperties(root, ks.Properties, "", nil, nil, columns)
+	for _, c := range columns {
+		c.maxDef = len(c.groups)
+		if !c.required {
+			c.maxDef++
+		}
+		if c.repeated {
+			c.maxRep = 1
+		}
+	}
+
+	pw := &pqWriter{w: bufio.NewWriterSize(w, 32768), opts: opts, columns: columns}
+	pw.write([]byte("PAR1"))
+	rows := 0
+	err := readEntities(r, func(rec Entity) error {
+		if rec.Key.Kind != ks.Kind {
+			return nil
+		}
+		if err := pw.addRow(rec); err != nil {
+			return fmt.Errorf("%s: %v", MarshalKey(rec.Key), err)
+		}
+		if rows++; rows == opts.RowGroupSize {
+			rows = 0
+			return pw.flushRowGroup()
+		}
+		return nil
+	})
+	if err == nil && rows > 0 {
+		err = pw.flushRowGroup()
+	}
+	if err != nil {
+		return err
+	}
+	var footer bytes.Buffer
+	err = thriftStruct{
+		{1, int32(1)},
+		{2, thriftList{thriftTypeStruct, pqSchema(root, nil)}},
+		{3, pw.numRows},
+		{4, thriftList{thriftTypeStruct, pw.rowGroups}},
+		{6, "dsutil"},
+	}.encode(&footer)
+	if err != nil {
+		return err
+	}
+	pw.write(footer.Bytes())
+	pw.write(binary.LittleEndian.AppendUint32(nil, uint32(footer.Len())))
+	pw.write([]byte("PAR1"))
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
+
+func nonEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// pqAddProperties adds the nodes of the given properties, whose names start with path, to parent.
+// Dotted property names become groups, unless there's also a property with the group name.
+func pqAddProperties(parent *pqNode, props []*PropertySchema, path string, groupPath, groups []string, columns []*pqColumn) []*pqColumn {
+	scalars := make(map[string]bool)
+	for _, p := range props {
+		if rel := p.Name[len(path):]; !strings.Contains(rel, ".") {
+			scalars[rel] = true
+		}
+	}
+	nested := make(map[string][]*PropertySchema)
+	var order []*PropertySchema // nil for groups
+	var nestedOrder []string
+	for _, p := range props {
+		rel := p.Name[len(path):]
+		if head, _, dotted := strings.Cut(rel, "."); dotted && !scalars[head] && head != "" {
+			if _, ok := nested[head]; !ok {
+				order = append(order, nil)
+				nestedOrder = append(nestedOrder, head)
+			}
+			nested[head] = append(nested[head], p)
+		} else {
+			order = append(order, p)
+		}
+	}
+	for _, p := range order {
+		if p == nil {
+			head := nestedOrder[0]
+			nestedOrder = nestedOrder[1:]
+			g := &pqNode{name: head}
+			parent.children = append(parent.children, g)
+			columns = pqAddProperties(g, nested[head], path+head+".", slices.Concat(groupPath, []string{head}),
+				slices.Concat(groups, []string{path + head + "."}), columns)
+			continue
+		}
+		rel := p.Name[len(path):]
+		c := &pqColumn{path: slices.Concat(groupPath, []string{rel}), typ: pqValueType(p), repeated: p.Repeated, groups: groups}
+		c.values = func(_ Entity, props map[string][]any) []any {
+			values := props[p.Name]
+			if !c.repeated && len(values) > 1 {
+				values = values[:1]
+			}
+			return values
+		}
+		parent.children = append(parent.children, &pqNode{name: rel, column: c})
+		columns = append(columns, c)
+	}
+	return columns
+}
+
+// pqValueType returns the value type of a property column: its type if there's a single one,
+// float for int and float values, and "any" (typed literals) for other mixed types.
+func pqValueType(p *PropertySchema) string {
+	var types []string
+	for _, t := range p.Types {
+		if t != "null" {
+			types = append(types, t)
+		}
+	}
+	switch {
+	case len(types) == 0:
+		return "string"
+	case len(types) == 1:
+		return types[0]
+	case len(types) == 2 && slices.Contains(types, "int") && slices.Contains(types, "float"):
+		return "float"
+	}
+	return "any"
+}
+
+// pqSchema returns the schema elements of a node and its descendants, depth-first.
+func pqSchema(n *pqNode, res []any) []any {
+	if n.column == nil {
+		el := thriftStruct{}
+		if len(res) > 0 { // not the root
+			el = append(el, thriftField{3, int32(pqOptional)})
+		}
+		el = append(el, thriftField{4, n.name}, thriftField{5, int32(len(n.children))})
+		res = append(res, el)
+		for _, child := range n.children {
+			res = pqSchema(child, res)
+		}
+		return res
+	}
+	c := n.column
+	repetition := pqOptional
+	switch {
+	case c.required:
+		repetition = pqRequired
+	case c.repeated:
+		repetition = pqRepeated
+	}
+	el := thriftStruct{{1, int32(c.physicalType())}, {3, int32(repetition)}, {4, n.name}}
+	switch c.typ {
+	case "string", "key", "any":
+		el = append(el, thriftField{6, int32(pqUTF8)}, thriftField{10, thriftStruct{{1, thriftStruct{}}}})
+	case "time":
+		unit := thriftStruct{{2, thriftStruct{}}} // MICROS
+		el = append(el, thriftField{6, int32(pqTimestampMicros)}, thriftField{10, thriftStruct{{8, thriftStruct{{1, true}, {2, unit}}}}})
+	}
+	return append(res, el)
+}
+
+func (c *pqColumn) physicalType() int {
+	switch c.typ {
+	case "int", "time":
+		return pqInt64
+	case "float":
+		return pqDouble
+	case "bool":
+		return pqBoolean
+	}
+	return pqByteArray
+}
+
+// add appends a value to the current row group, in PLAIN encoding.
+func (c *pqColumn) add(value any) error {
+	switch v := value.(type) {
+	case int64:
+		switch c.typ {
+		case "int":
+			c.data.Write(binary.LittleEndian.AppendUint64(nil, uint64(v)))
+			return nil
+		case "float":
+			c.data.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(float64(v))))
+			return nil
+		}
+	case float64:
+		if c.typ == "float" {
+			c.data.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)))
+			return nil
+		}
+	case time.Time:
+		if c.typ == "time" {
+			c.data.Write(binary.LittleEndian.AppendUint64(nil, uint64(v.UnixMicro())))
+			return nil
+		}
+	case bool:
+		if c.typ == "bool" {
+			c.bools = append(c.bools, v)
+			return nil
+		}
+	case string:
+		if c.typ == "string" {
+			c.addBytes([]byte(v))
+			return nil
+		}
+	case []byte:
+		if c.typ == "blob" {
+			c.addBytes(v)
+			return nil
+		}
+	case *datastore.Key:
+		if c.typ == "key" {
+			c.addBytes([]byte(MarshalKey(v)))
+			return nil
+		}
+	}
+	if c.typ == "any" {
+		s, err := FormatTypedValue(value)
+		if err != nil {
+			return err
+		}
+		c.addBytes([]byte(s))
+		return nil
+	}
+	return fmt.Errorf("Unexpected %s value of %s", valueTypeName(value), strings.Join(c.path, "."))
+}
+
+func (c *pqColumn) addBytes(b []byte) {
+	c.data.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(b))))
+	c.data.Write(b)
+}
+
+type pqWriter struct {
+	w         *bufio.Writer
+	opts      ParquetOptions
+	columns   []*pqColumn
+	pos       int64
+	err       error
+	numRows   int64
+	rows      int64 // rows of the current row group
+	rowGroups []any
+}
+
+func (pw *pqWriter) write(b []byte) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = pw.w.Write(b)
+	pw.pos += int64(len(b))
+}
+
+// addRow adds the levels and values of an entity to each column.
+func (pw *pqWriter) addRow(rec Entity) error {
+	props, _ := groupProperties(rec.Properties)
+	defined := make(map[string]bool) // groups with properties
+	for name := range props {
+		for i := range len(name) {
+			if name[i] == '.' {
+				defined[name[:i+1]] = true
+			}
+		}
+	}
+	for _, c := range pw.columns {
+		n := 0
+		for _, v := range c.values(rec, props) {
+			if v == nil {
+				continue
+			}
+			if err := c.add(v); err != nil {
+				return err
+			}
+			c.reps = append(c.reps, min(n, 1))
+			c.defs = append(c.defs, c.maxDef)
+			n++
+		}
+		if n == 0 {
+			if c.required {
+				return fmt.Errorf("Missing required %s", strings.Join(c.path, "."))
+			}
+			def := 0
+			for _, g := range c.groups {
+				if !defined[g] {
+					break
+				}
+				def++
+			}
+			c.reps = append(c.reps, 0)
+			c.defs = append(c.defs, def)
+		}
+	}
+	pw.rows++
+	return nil
+}
+
+// flushRowGroup writes the current row group, with a single data page per column.
+func (pw *pqWriter) flushRowGroup() error {
+	var chunks []any
+	var totalSize int64
+	for _, c := range pw.columns {
+		var page bytes.Buffer
+		if c.maxRep > 0 {
+			pqWriteLevels(&page, c.reps, c.maxRep)
+		}
+		if c.maxDef > 0 {
+			pqWriteLevels(&page, c.defs, c.maxDef)
+		}
+		if len(c.bools) > 0 {
+			packed := make([]byte, (len(c.bools)+7)/8)
+			for i, v := range c.bools {
+				if v {
+					packed[i/8] |= 1 << (i % 8)
+				}
+			}
+			c.data.Write(packed)
+		}
+		page.Write(c.data.Bytes())
+		data := page.Bytes()
+		codec := pqUncompressed
+		if !pw.opts.Uncompressed {
+			var zbuf bytes.Buffer
+			zw := gzip.NewWriter(&zbuf)
+			if _, err := zw.Write(data); err != nil {
+				return err
+			}
+			if err := zw.Close(); err != nil {
+				return err
+			}
+			data, codec = zbuf.Bytes(), pqGzip
+		}
+		var header bytes.Buffer
+		err := thriftStruct{
+			{1, int32(0)}, // DATA_PAGE
+			{2, int32(page.Len())},
+			{3, int32(len(data))},
+			{5, thriftStruct{{1, int32(len(c.defs))}, {2, int32(pqPlain)}, {3, int32(pqRLE)}, {4, int32(pqRLE)}}},
+		}.encode(&header)
+		if err != nil {
+			return err
+		}
+		offset := pw.pos
+		pw.write(header.Bytes())
+		pw.write(data)
+		path := make([]any, len(c.path))
+		for i, name := range c.path {
+			path[i] = name
+		}
+		uncompressedSize := int64(header.Len() + page.Len())
+		totalSize += uncompressedSize
+		chunks = append(chunks, thriftStruct{
+			{2, offset},
+			{3, thriftStruct{
+				{1, int32(c.physicalType())},
+				{2, thriftList{thriftTypeI32, []any{int32(pqPlain), int32(pqRLE)}}},
+				{3, thriftList{thriftTypeBinary, path}},
+				{4, int32(codec)},
+				{5, int64(len(c.defs))},
+				{6, uncompressedSize},
+				{7, int64(header.Len() + len(data))},
+				{9, offset},
+			}},
+		})
+		c.defs, c.reps, c.bools = c.defs[:0], c.reps[:0], c.bools[:0]
+		c.data.Reset()
+	}
+	pw.rowGroups = append(pw.rowGroups, thriftStruct{
+		{1, thriftList{thriftTypeStruct, chunks}},
+		{2, totalSize},
+		{3, pw.rows},
+	})
+	pw.numRows += pw.rows
+	pw.rows = 0
+	return pw.err
+}
+
+// pqWriteLevels writes levels in the RLE/bit-packing hybrid encoding (using RLE runs only), prefixed by their length.
+func pqWriteLevels(b *bytes.Buffer, levels []int, maxLevel int) {
+	width := (bits.Len(uint(maxLevel)) + 7) / 8
+	var runs []byte
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		runs = binary.AppendUvarint(runs, uint64(j-i)<<1)
+		for k := range width {
+			runs = append(runs, byte(levels[i]>>(8*k)))
+		}
+		i = j
+	}
+	b.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(runs))))
+	b.Write(runs)
+}
@@ -0,0 +1,361 @@
+package dsio
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// PostgresOptions controls how WritePostgres maps entities to tables.
+type PostgresOptions struct {
+	// Arrays is "array" (default) to store repeated properties in PostgreSQL arrays, or "jsonb".
+	Arrays string
+	// Nested is "columns" (default) to store each dotted property of embedded entities in its own column,
+	// or "jsonb" to store each embedded entity in a single jsonb column.
+	Nested string
+	// Keys is "text" (default) to store the key in a "__key__" column in dsutil notation,
+	// or "columns" to also store its components in "__namespace__", "__parent__", "__id__" and "__name__" columns.
+	Keys string
+}
+
+var pgTypes = map[string]string{
+	"string": "text",
+	"int":    "bigint",
+	"float":  "double precision",
+	"bool":   "boolean",
+	"time":   "timestamptz",
+	"key":    "text",
+	"blob":   "bytea",
+}
+
+type pgTable struct {
+	name    string
+	columns []pgColumn
+}
+
+type pgColumn struct {
+	name, typ string
+	// value returns the COPY text of the column
+	value func(rec Entity, values map[string][]any) (string, error)
+}
+
+// WritePostgres writes a SQL script for psql creating a table for each of the given kinds (as inferred by ReadSchema)
+// and loading the entities of the export stream r with COPY ... FROM stdin.
+// Mixed-type properties are stored as text, except int and float which are stored as double precision.
+func WritePostgres(w io.Writer, kinds []*KindSchema, r io.Reader, opts PostgresOptions) error {
+	switch opts.Arrays {
+	case "", "array", "jsonb":
+	default:
+		return fmt.Errorf("Unsupported arrays option %q", opts.Arrays)
+	}
+	switch opts.Nested {
+	case "", "columns", "jsonb":
+	default:
+		return fmt.Errorf("Unsupported nested option %q", opts.Nested)
+	}
+	switch opts.Keys {
+	case "", "text", "columns":
+	default:
+		return fmt.Errorf("Unsupported keys option %q", opts.Keys)
+	}
+	bw := bufio.NewWriterSize(w, 32768)
+	tables := make(map[string]*pgTable)
+	for _, ks := range kinds {
+		t := newPgTable(ks, opts)
+		tables[ks.Kind] = t
+		fmt.Fprintf(bw, "CREATE TABLE %s (\n", pgIdent(t.name))
+		for i, c := range t.columns {
+			fmt.Fprintf(bw, "\t%s %s", pgIdent(c.name), c.typ)
+			if i == 0 {
+				bw.WriteString(" PRIMARY KEY")
+			}
+			if i < len(t.columns)-1 {
+				bw.WriteString(",")
+			}
+			bw.WriteString("\n")
+		}
+		bw.WriteString(");\n\n")
+	}
+	var current *pgTable
+	err := readEntities(r, func(rec Entity) error {
+		t := tables[rec.Key.Kind]
+		if t == nil {
+			return fmt.Errorf("Kind %s is missing from the schema", rec.Key.Kind)
+		}
+		if t != current {
+			// the entities of a kind are usually contiguous, otherwise the table gets several COPY blocks
+			if current != nil {
+				bw.WriteString("\\.\n\n")
+			}
+			current = t
+			names := make([]string, len(t.columns))
+			for i, c := range t.columns {
+				names[i] = pgIdent(c.name)
+			}
+			fmt.Fprintf(bw, "COPY %s (%s) FROM stdin;\n", pgIdent(t.name), strings.Join(names, ", "))
+		}
+		values, _ := groupProperties(rec.Properties)
+		for i, c := range t.columns {
+			if i > 0 {
+				bw.WriteByte('\t')
+			}
+			s, err := c.value(rec, values)
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", MarshalKey(rec.Key), c.name, err)
+			}
+			bw.WriteString(s)
+		}
+		bw.WriteByte('\n')
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		bw.WriteString("\\.\n")
+	}
+	return bw.Flush()
+}
+
+func newPgTable(ks *KindSchema, opts PostgresOptions) *pgTable {
+	t := &pgTable{name: ks.Kind}
+	used := make(map[string]bool)
+	add := func(name, typ string, value func(rec Entity, values map[string][]any) (string, error)) {
+		t.columns = append(t.columns, pgColumn{name: uniqueIdent(used, name), typ: typ, value: value})
+	}
+	add("__key__", "text", func(rec Entity, _ map[string][]any) (string, error) {
+		return pgCopyText(MarshalKey(rec.Key)), nil
+	})
+	if opts.Keys == "columns" {
+		add("__namespace__", "text", func(rec Entity, _ map[string][]any) (string, error) {
+			return pgCopyValue(rec.Key.Namespace)
+		})
+		add("__parent__", "text", func(rec Entity, _ map[string][]any) (string, error) {
+			if rec.Key.Parent == nil {
+				return pgCopyValue(nil)
+			}
+			return pgCopyValue(rec.Key.Parent)
+		})
+		add("__id__", "bigint", func(rec Entity, _ map[string][]any) (string, error) {
+			if rec.Key.Name != "" {
+				return pgCopyValue(nil)
+			}
+			return pgCopyValue(rec.Key.ID)
+		})
+		add("__name__", "text", func(rec Entity, _ map[string][]any) (string, error) {
+			if rec.Key.Name == "" {
+				return pgCopyValue(nil)
+			}
+			return pgCopyValue(rec.Key.Name)
+		})
+	}
+	nested := make(map[string][]*PropertySchema)
+	for _, p := range ks.Properties {
+		head, _, dotted := strings.Cut(p.Name, ".")
+		if opts.Nested == "jsonb" && dotted && head != "" {
+			if _, ok := nested[head]; !ok {
+				add(head, "jsonb", func(rec Entity, values map[string][]any) (string, error) {
+					return pgNestedValue(nested[head], len(head)+1, values)
+				})
+			}
+			nested[head] = append(nested[head], p)
+			continue
+		}
+		typ := pgPropertyType(p)
+		switch {
+		case !p.Repeated:
+			add(p.Name, typ, func(_ Entity, values map[string][]any) (string, error) {
+				if len(values[p.Name]) == 0 {
+					return pgCopyValue(nil)
+				}
+				return pgCopyValue(values[p.Name][0])
+			})
+		case opts.Arrays == "jsonb":
+			add(p.Name, "jsonb", func(_ Entity, values map[string][]any) (string, error) {
+				return pgJSONValue(pgJSONArray(values[p.Name]))
+			})
+		default:
+			add(p.Name, typ+"[]", func(_ Entity, values map[string][]any) (string, error) {
+				return pgArrayValue(values[p.Name])
+			})
+		}
+	}
+	return t
+}
+
+// pgPropertyType returns the column type of a property, ignoring whether it's repeated.
+func pgPropertyType(p *PropertySchema) string {
+	var types []string
+	for _, t := range p.Types {
+		if t != "null" {
+			types = append(types, t)
+		}
+	}
+	switch {
+	case len(types) == 1 && pgTypes[types[0]] != "":
+		return pgTypes[types[0]]
+	case len(types) == 2 && (types[0] == "int" && types[1] == "float" || types[0] == "float" && types[1] == "int"):
+		return "double precision"
+	}
+	return "text"
+}
+
+// pgIdent returns a quoted SQL identifier.
+func pgIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+var pgCopyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// pgCopyText escapes a value for the COPY text format.
+func pgCopyText(s string) string {
+	return pgCopyEscaper.Replace(s)
+}
+
+// pgCopyValue returns the COPY text of a scalar value.
+func pgCopyValue(value any) (string, error) {
+	if value == nil {
+		return `\N`, nil
+	}
+	s, err := pgText(value)
+	return pgCopyText(s), err
+}
+
+// pgText returns the PostgreSQL text representation of a scalar value.
+func pgText(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN", nil
+		case math.IsInf(v, 1):
+			return "Infinity", nil
+		case math.IsInf(v, -1):
+			return "-Infinity", nil
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case bool:
+		if v {
+			return "t", nil
+		}
+		return "f", nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), nil
+	case *datastore.Key:
+		return MarshalKey(v), nil
+	case []byte:
+		return `\x` + hex.EncodeToString(v), nil
+	}
+	return "", fmt.Errorf("Unsupported data type %T", value)
+}
+
+var pgArrayEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// pgArrayValue returns the COPY text of an array literal.
+func pgArrayValue(values []any) (string, error) {
+	if len(values) == 0 {
+		return `\N`, nil
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		if v == nil {
+			sb.WriteString("NULL")
+			continue
+		}
+		s, err := pgText(v)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(`"` + pgArrayEscaper.Replace(s) + `"`)
+	}
+	sb.WriteByte('}')
+	return pgCopyText(sb.String()), nil
+}
+
+// pgNestedValue returns the COPY text of a jsonb object holding the given properties, without their prefix.
+func pgNestedValue(props []*PropertySchema, prefixLen int, values map[string][]any) (string, error) {
+	var obj map[string]any
+	for _, p := range props {
+		vals := values[p.Name]
+		if len(vals) == 0 {
+			continue
+		}
+		if obj == nil {
+			obj = make(map[string]any)
+		}
+		path := strings.Split(p.Name[prefixLen:], ".")
+		m := obj
+		for _, name := range path[:len(path)-1] {
+			child, ok := m[name].(map[string]any)
+			if !ok {
+				child = make(map[string]any)
+				m[name] = child
+			}
+			m = child
+		}
+		if p.Repeated {
+			m[path[len(path)-1]] = pgJSONArray(vals)
+		} else {
+			m[path[len(path)-1]] = pgJSON(vals[0])
+		}
+	}
+	if obj == nil {
+		return `\N`, nil
+	}
+	return pgJSONValue(obj)
+}
+
+// pgJSONValue returns the COPY text of a jsonb value, or null if value is nil.
+func pgJSONValue(value any) (string, error) {
+	if value == nil {
+		return `\N`, nil
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return pgCopyText(string(b)), nil
+}
+
+func pgJSONArray(values []any) any {
+	if len(values) == 0 {
+		return nil
+	}
+	res := make([]any, len(values))
+	for i, v := range values {
+		res[i] = pgJSON(v)
+	}
+	return res
+}
+
+// pgJSON converts a value to its JSON representation: timestamps as RFC 3339 strings, keys in dsutil notation
+// and blobs as base64 strings.
+func pgJSON(value any) any {
+	switch v := value.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case *datastore.Key:
+		return MarshalKey(v)
+	}
+	return value
+}
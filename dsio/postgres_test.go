@@ -0,0 +1,78 @@
+package dsio
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePostgres(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	entities := []Entity{
+		{Key: datastore.IDKey("Event", 1, nil), Properties: datastore.PropertyList{
+			{Name: "title", Value: "a\tb \\ c"},
+			{Name: "at", Value: ts},
+			{Name: "tags", Value: []any{"x", `y"z`}},
+			{Name: "geo.lat", Value: 1.5},
+			{Name: "geo.lng", Value: int64(2)},
+			{Name: "data", Value: []byte{1, 0xab}},
+		}},
+		{Key: datastore.NameKey("Event", "e2", datastore.NameKey("User", "u", nil)), Properties: datastore.PropertyList{
+			{Name: "title", Value: "e2"},
+			{Name: "tags", Value: []any{"w"}},
+			{Name: "ok", Value: true},
+		}},
+	}
+	convert := func(opts PostgresOptions) string {
+		kinds, err := ReadSchema(encodeEntities(t, nil, entities...))
+		require.NoError(t, err)
+		var buf bytes.Buffer
+		require.NoError(t, WritePostgres(&buf, kinds, encodeEntities(t, nil, entities...), opts))
+		return buf.String()
+	}
+
+	assert.Equal(t, `CREATE TABLE "Event" (
+	"__key__" text PRIMARY KEY,
+	"at" timestamptz,
+	"data" bytea,
+	"geo.lat" double precision,
+	"geo.lng" bigint,
+	"ok" boolean,
+	"tags" text[],
+	"title" text
+);
+
+COPY "Event" ("__key__", "at", "data", "geo.lat", "geo.lng", "ok", "tags", "title") FROM stdin;
+/Event,1	2024-05-01T10:30:00Z	\\x01ab	1.5	2	\N	{"x","y\\"z"}	a\tb \\ c
+/User,u/Event,e2	\N	\N	\N	\N	t	{"w"}	e2
+\.
+`, convert(PostgresOptions{}))
+
+	assert.Equal(t, `CREATE TABLE "Event" (
+	"__key__" text PRIMARY KEY,
+	"__namespace__" text,
+	"__parent__" text,
+	"__id__" bigint,
+	"__name__" text,
+	"at" timestamptz,
+	"data" bytea,
+	"geo" jsonb,
+	"ok" boolean,
+	"tags" jsonb,
+	"title" text
+);
+
+COPY "Event" ("__key__", "__namespace__", "__parent__", "__id__", "__name__", "at", "data", "geo", "ok", "tags", "title") FROM stdin;
+/Event,1		\N	1	\N	2024-05-01T10:30:00Z	\\x01ab	{"lat":1.5,"lng":2}	\N	["x","y\\"z"]	a\tb \\ c
+/User,u/Event,e2		/User,u	\N	e2	\N	\N	\N	t	["w"]	e2
+\.
+`, convert(PostgresOptions{Arrays: "jsonb", Nested: "jsonb", Keys: "columns"}))
+
+	err := WritePostgres(&bytes.Buffer{}, nil, strings.NewReader(""), PostgresOptions{Arrays: "list"})
+	assert.EqualError(t, err, `Unsupported arrays option "list"`)
+}
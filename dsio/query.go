@@ -0,0 +1,288 @@
+package dsio
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// Query describes a DataStore query; unlike datastore.Query its parts can be inspected.
+type Query struct {
+	Kind       string // empty for kindless queries
+	Namespace  string
+	Ancestor   *datastore.Key
+	Filter     datastore.EntityFilter
+	Orders     []string // property names, "-" prefix for descending order
+	Projection []string
+	Distinct   bool
+	DistinctOn []string
+	KeysOnly   bool
+	Limit      int
+	Offset     int
+	ReadTime   time.Time // read a consistent snapshot at this time, zero for the latest data
+}
+
+// DatastoreQuery returns the equivalent datastore.Query.
+func (q *Query) DatastoreQuery() *datastore.Query {
+	dq := datastore.NewQuery(q.Kind)
+	if q.Namespace != "" {
+		dq = dq.Namespace(q.Namespace)
+	}
+	if q.Ancestor != nil {
+		dq = dq.Ancestor(q.Ancestor)
+	}
+	if q.Filter != nil {
+		dq = dq.FilterEntity(q.Filter)
+	}
+	for _, o := range q.Orders {
+		dq = dq.Order(o)
+	}
+	if len(q.Projection) > 0 {
+		dq = dq.Project(q.Projection...)
+	}
+	if q.Distinct {
+		dq = dq.Distinct()
+	}
+	if len(q.DistinctOn) > 0 {
+		dq = dq.DistinctOn(q.DistinctOn...)
+	}
+	if q.KeysOnly {
+		dq = dq.KeysOnly()
+	}
+	if q.Limit != 0 {
+		dq = dq.Limit(q.Limit)
+	}
+	if q.Offset != 0 {
+		dq = dq.Offset(q.Offset)
+	}
+	return dq
+}
+
+// String returns the query in GQL syntax, as accepted by ParseGQL.
+func (q *Query) String() string {
+	var sb strings.Builder
+	sb.WriteString("SELECT ")
+	if len(q.DistinctOn) > 0 {
+		sb.WriteString("DISTINCT ON (" + formatNames(q.DistinctOn) + ") ")
+	} else if q.Distinct {
+		sb.WriteString("DISTINCT ")
+	}
+	switch {
+	case len(q.Projection) > 0:
+		sb.WriteString(formatNames(q.Projection))
+	case q.KeysOnly:
+		sb.WriteString("__key__")
+	default:
+		sb.WriteString("*")
+	}
+	if q.Kind != "" {
+		sb.WriteString(" FROM " + quoteGQLName(q.Kind))
+	}
+	var where []string
+	if q.Filter != nil {
+		f := FormatFilter(q.Filter)
+		if _, ok := q.Filter.(datastore.OrFilter); ok && q.Ancestor != nil {
+			f = "(" + f + ")"
+		}
+		where = append(where, f)
+	}
+	if q.Ancestor != nil {
+		where = append(where, "__key__ HAS ANCESTOR "+FormatGQLKey(q.Ancestor))
+	}
+	if len(where) > 0 {
+		sb.WriteString(" WHERE " + strings.Join(where, " AND "))
+	}
+	if len(q.Orders) > 0 {
+		var orders []string
+		for _, o := range q.Orders {
+			if name, ok := strings.CutPrefix(o, "-"); ok {
+				orders = append(orders, quoteGQLName(name)+" DESC")
+			} else {
+				orders = append(orders, quoteGQLName(o))
+			}
+		}
+		sb.WriteString(" ORDER BY " + strings.Join(orders, ", "))
+	}
+	if q.Limit != 0 {
+		sb.WriteString(" LIMIT " + strconv.Itoa(q.Limit))
+	}
+	if q.Offset != 0 {
+		sb.WriteString(" OFFSET " + strconv.Itoa(q.Offset))
+	}
+	return sb.String()
+}
+
+func formatNames(names []string) string {
+	res := make([]string, len(names))
+	for i, n := range names {
+		res[i] = quoteGQLName(n)
+	}
+	return strings.Join(res, ", ")
+}
+
+// FormatFilter returns a filter in GQL syntax.
+func FormatFilter(f datastore.EntityFilter) string {
+	switch f := f.(type) {
+	case datastore.AndFilter:
+		parts := make([]string, len(f.Filters))
+		for i, sub := range f.Filters {
+			parts[i] = FormatFilter(sub)
+			if _, ok := sub.(datastore.OrFilter); ok {
+				parts[i] = "(" + parts[i] + ")"
+			}
+		}
+		return strings.Join(parts, " AND ")
+	case datastore.OrFilter:
+		parts := make([]string, len(f.Filters))
+		for i, sub := range f.Filters {
+			parts[i] = FormatFilter(sub)
+		}
+		return strings.Join(parts, " OR ")
+	case datastore.PropertyFilter:
+		op := f.Operator
+		switch op {
+		case "in":
+			op = "IN"
+		case "not-in":
+			op = "NOT IN"
+		}
+		return quoteGQLName(f.FieldName) + " " + op + " " + formatGQLValue(f.Value)
+	}
+	return fmt.Sprint(f)
+}
+
+// formatGQLValue returns a value as a GQL literal.
+func formatGQLValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return quoteGQL(v)
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	case int64, int, int32:
+		return fmt.Sprint(v)
+	case float64:
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eIN") {
+			s += ".0"
+		}
+		return s
+	case time.Time:
+		return "DATETIME(" + quoteGQL(v.UTC().Format(time.RFC3339Nano)) + ")"
+	case *datastore.Key:
+		return FormatGQLKey(v)
+	case []byte:
+		return "BLOB(" + quoteGQL(base64.StdEncoding.EncodeToString(v)) + ")"
+	case []any:
+		parts := make([]string, len(v))
+		for i, e := range v {
+			parts[i] = formatGQLValue(e)
+		}
+		return "ARRAY(" + strings.Join(parts, ", ") + ")"
+	}
+	return quoteGQL(fmt.Sprint(value))
+}
+
+// newSnapshot starts a read-only transaction reading DataStore at the query's ReadTime.
+// Returns nil if ReadTime is not set.
+func (q *Query) newSnapshot(ds *datastore.Client) (*datastore.Transaction, error) {
+	if q.ReadTime.IsZero() {
+		return nil, nil
+	}
+	return ds.NewTransaction(context.Background(), datastore.ReadOnly, datastore.WithReadTime(q.ReadTime))
+}
+
+// inSnapshot returns the equivalent datastore.Query, running in the given snapshot transaction if not nil.
+func (q *Query) inSnapshot(tx *datastore.Transaction) *datastore.Query {
+	dq := q.DatastoreQuery()
+	if tx != nil {
+		dq = dq.Transaction(tx)
+	}
+	return dq
+}
+
+// snapshotPageSize is the number of entities read per snapshot transaction.
+const snapshotPageSize = 1000
+
+// run returns the entities matching the query. If ReadTime is set, the entities are read a page at a time,
+// each page in a new read-only transaction at ReadTime continuing from the cursor of the previous page,
+// as a single transaction would expire (after about 270 seconds) during a long export.
+func (q *Query) run(ds *datastore.Client) entitySource {
+	ctx := context.Background()
+	if q.ReadTime.IsZero() {
+		return iteratorSource(ds.Run(ctx, q.DatastoreQuery()))
+	}
+	var (
+		it          *datastore.Iterator
+		tx          *datastore.Transaction
+		cursor      *datastore.Cursor
+		page, limit int
+		read        int
+	)
+	return func() (rec Entity, err error) {
+		for {
+			if it == nil {
+				if cursor != nil && page < limit || q.Limit > 0 && read >= q.Limit {
+					return rec, iterator.Done
+				}
+				if tx, err = q.newSnapshot(ds); err != nil {
+					return rec, err
+				}
+				limit = snapshotPageSize
+				if q.Limit > 0 {
+					limit = min(limit, q.Limit-read)
+				}
+				dq := q.DatastoreQuery().Transaction(tx).Limit(limit)
+				if cursor != nil {
+					dq = dq.Start(*cursor).Offset(0)
+				}
+				it, page = ds.Run(ctx, dq), 0
+			}
+			rec.Key, err = it.Next(&rec.Properties)
+			if err == iterator.Done {
+				var c datastore.Cursor
+				c, err = it.Cursor()
+				tx.Rollback()
+				if it = nil; err != nil {
+					return rec, err
+				}
+				cursor = &c
+				continue
+			}
+			if err != nil {
+				tx.Rollback()
+				return rec, err
+			}
+			page++
+			read++
+			return rec, nil
+		}
+	}
+}
+
+// CountQuery returns the number of entities matching the query, using an aggregation query.
+func CountQuery(ds *datastore.Client, q *Query) (int64, error) {
+	tx, err := q.newSnapshot(ds)
+	if err != nil {
+		return 0, err
+	}
+	if tx != nil {
+		defer tx.Rollback()
+	}
+	aq := q.inSnapshot(tx).NewAggregationQuery().WithCount("count")
+	res, err := ds.RunAggregationQuery(context.Background(), aq)
+	if err != nil {
+		return 0, err
+	}
+	if v, ok := res["count"].(interface{ GetIntegerValue() int64 }); ok {
+		return v.GetIntegerValue(), nil
+	}
+	return 0, fmt.Errorf("Unexpected count result %v", res["count"])
+}
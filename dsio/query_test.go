@@ -0,0 +1,20 @@
+package dsio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuery_String(t *testing.T) {
+	for _, gql := range []string{
+		"SELECT * FROM A",
+		"SELECT __key__ FROM A WHERE a = 1 AND (b = 'x' OR c IN ARRAY(1.0, 2.5)) AND __key__ HAS ANCESTOR KEY(P, 'p') ORDER BY a DESC, b LIMIT 5 OFFSET 10",
+		"SELECT DISTINCT ON (a) a, `b c` FROM `My Kind` WHERE d >= DATETIME('2024-01-01T00:00:00.5Z') AND e != NULL AND f NOT IN ARRAY(TRUE) AND g = BLOB('AQI=')",
+		"SELECT * WHERE (a = 'it\\'s' OR b < -5) AND __key__ HAS ANCESTOR KEY(NAMESPACE('ns'), P, 1)",
+	} {
+		q, err := ParseGQL(gql, nil)
+		require.NoError(t, err, gql)
+		require.Equal(t, gql, q.String())
+	}
+}
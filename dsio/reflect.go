@@ -5,6 +5,10 @@ import (
 	"io"
 	"log"
 	"reflect"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/datastore"
 )
 
 // ModelMapping wraps a single data model type.
@@ -17,6 +21,11 @@ type ModelMapping struct {
 	ImportFunc ImportFuncType
 	// BatchSize defines the desired number of elements for a single ImportFunc call.
 	BatchSize int
+	// UnknownFieldPolicy defines how properties without a matching struct field are handled.
+	UnknownFieldPolicy UnknownFieldPolicy
+	// UnknownFieldsFunc, if set, is called after a successful import with the sorted names
+	// of the properties without a matching struct field, if there were any.
+	UnknownFieldsFunc func(kind string, names []string)
 }
 
 // ImportFuncType is the type of the import callback.
@@ -46,6 +55,7 @@ func ImportStreamReflect(r io.Reader, modelMap []ModelMapping) (err error) {
 				for _, m := range modelMap {
 					if m.Kind == e.Key.Kind {
 						tmp = NewReflector(m.TypePtr)
+						tmp.UnknownFieldPolicy = m.UnknownFieldPolicy
 						model = m
 						break
 					}
@@ -55,11 +65,12 @@ func ImportStreamReflect(r io.Reader, modelMap []ModelMapping) (err error) {
 					return
 				}
 			}
-			tmp.Reset()
-			for _, p := range e.Properties {
-				tmp.Set(p.Name, p.Value)
+			row, err := tmp.Load(e)
+			if err != nil {
+				errCh <- err
+				return
 			}
-			rows = append(rows, tmp.MakeCopy())
+			rows = append(rows, row)
 			if len(rows) >= model.BatchSize {
 				err := model.ImportFunc(model.Kind, rows)
 				if err != nil {
@@ -73,6 +84,12 @@ func ImportStreamReflect(r io.Reader, modelMap []ModelMapping) (err error) {
 			err := model.ImportFunc(model.Kind, rows)
 			if err != nil {
 				errCh <- err
+				return
+			}
+		}
+		if tmp != nil && model.UnknownFieldsFunc != nil {
+			if names := tmp.UnknownFields(); len(names) > 0 {
+				model.UnknownFieldsFunc(model.Kind, names)
 			}
 		}
 	}()
@@ -80,12 +97,28 @@ func ImportStreamReflect(r io.Reader, modelMap []ModelMapping) (err error) {
 	return
 }
 
+// UnknownFieldPolicy defines how Reflector handles properties without a matching struct field.
+type UnknownFieldPolicy int
+
+const (
+	// UnknownFieldLog logs each unknown field once and skips it (default).
+	UnknownFieldLog UnknownFieldPolicy = iota
+	// UnknownFieldIgnore silently skips unknown fields.
+	UnknownFieldIgnore
+	// UnknownFieldFail makes SetField and Load return an error for unknown fields.
+	UnknownFieldFail
+)
+
 // Reflector implements a caching reflection helper.
 type Reflector struct {
-	typ    reflect.Type
-	tmpptr reflect.Value
-	tmp    reflect.Value
-	fields map[string]*refField
+	// UnknownFieldPolicy defines how SetField and Load handle unknown fields.
+	UnknownFieldPolicy UnknownFieldPolicy
+	typ                reflect.Type
+	tmpptr             reflect.Value
+	tmp                reflect.Value
+	fields             map[string]*refField
+	loadSaver          bool
+	keyField           []int // index of the `datastore:"__key__"` field
 }
 
 type refField struct {
@@ -98,12 +131,20 @@ type refField struct {
 func NewReflector(typePtr any) *Reflector {
 	typ := reflect.TypeOf(typePtr).Elem()
 	tmpptr := reflect.New(typ)
-	return &Reflector{
-		typ:    typ,
-		tmpptr: tmpptr,
-		tmp:    tmpptr.Elem(),
-		fields: make(map[string]*refField),
+	_, loadSaver := tmpptr.Interface().(datastore.PropertyLoadSaver)
+	r := &Reflector{
+		typ:       typ,
+		tmpptr:    tmpptr,
+		tmp:       tmpptr.Elem(),
+		fields:    make(map[string]*refField),
+		loadSaver: loadSaver,
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		if f := typ.Field(i); f.Tag.Get("datastore") == "__key__" && f.Type == reflect.TypeOf(&datastore.Key{}) {
+			r.keyField = f.Index
+		}
 	}
+	return r
 }
 
 // Reset default-initializes the reflected object.
@@ -111,20 +152,42 @@ func (r *Reflector) Reset() {
 	r.tmp.Set(reflect.Zero(r.typ))
 }
 
-// Set sets a property in the reflected object.
+// Set sets a property in the reflected object. Unknown fields are skipped.
 func (r *Reflector) Set(field string, value any) {
+	_ = r.SetField(field, value)
+}
+
+// SetField is like Set, but returns an error for an unknown field with UnknownFieldFail policy.
+func (r *Reflector) SetField(field string, value any) error {
 	f, ok := r.fields[field]
 	if !ok {
 		ftmp := r.makeRefField(field)
 		r.fields[field] = ftmp
 		f = ftmp
-		if !f.IsValid() {
-			log.Printf("Skipping unknown field %q", field)
+		if !f.IsValid() && r.UnknownFieldPolicy == UnknownFieldLog {
+			log.Printf("Skipping unknown field %q in %v", field, r.typ)
+		}
+	}
+	if !f.IsValid() {
+		if r.UnknownFieldPolicy == UnknownFieldFail {
+			return fmt.Errorf("Unknown field %q in %v", field, r.typ)
 		}
+		return nil
 	}
-	if f.IsValid() {
-		f.setValue(&f.Value, value)
+	f.setValue(&f.Value, value)
+	return nil
+}
+
+// UnknownFields returns the sorted names of all unknown fields seen by Set and SetField so far.
+func (r *Reflector) UnknownFields() []string {
+	var res []string
+	for name, f := range r.fields {
+		if !f.IsValid() {
+			res = append(res, name)
+		}
 	}
+	sort.Strings(res)
+	return res
 }
 
 // MakeCopy returns a pointer to a copy of the reflected object.
@@ -134,10 +197,41 @@ func (r *Reflector) MakeCopy() any {
 	return vptr.Interface()
 }
 
+// Load returns a pointer to a new object populated from the given entity.
+// If the type implements datastore.PropertyLoadSaver (and datastore.KeyLoader),
+// its Load (and LoadKey) method is called instead, the same way datastore.Get does.
+func (r *Reflector) Load(e Entity) (any, error) {
+	if r.loadSaver {
+		vptr := reflect.New(r.typ)
+		var keyLoadErr error
+		if kl, ok := vptr.Interface().(datastore.KeyLoader); ok {
+			keyLoadErr = kl.LoadKey(e.Key)
+		}
+		loadErr := vptr.Interface().(datastore.PropertyLoadSaver).Load(e.Properties)
+		if keyLoadErr != nil {
+			return nil, keyLoadErr
+		}
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return vptr.Interface(), nil
+	}
+	r.Reset()
+	if r.keyField != nil {
+		r.tmp.FieldByIndex(r.keyField).Set(reflect.ValueOf(e.Key))
+	}
+	for _, p := range e.Properties {
+		if err := r.SetField(p.Name, p.Value); err != nil {
+			return nil, err
+		}
+	}
+	return r.MakeCopy(), nil
+}
+
 // setAny() can set any value, the rest of the code below is for performance only.
 
 func (r *Reflector) makeRefField(name string) *refField {
-	f := &refField{Value: r.tmp.FieldByName(name)}
+	f := &refField{Value: fieldByName(r.tmp, name)}
 	switch f.Kind() {
 	case reflect.Bool:
 		f.setValue = setBool
@@ -149,12 +243,60 @@ func (r *Reflector) makeRefField(name string) *refField {
 		f.setValue = setFloat
 	case reflect.String:
 		f.setValue = setString
+	case reflect.Slice:
+		if f.Type().Elem().Kind() == reflect.Uint8 {
+			f.setValue = setAny
+		} else {
+			f.setValue = appendValue
+		}
 	default:
 		f.setValue = setAny
 	}
 	return f
 }
 
+// fieldByName returns the struct field of a property: the field with the same `datastore` tag name,
+// or the same name if it has no tag name. Otherwise, for compatibility, the field with the same Go name.
+// Fields tagged "-" are skipped, like datastore does.
+// Properties of nested structs are addressed with dotted names, e.g. "address.city",
+// and the fields of untagged embedded structs are promoted.
+func fieldByName(v reflect.Value, name string) reflect.Value {
+	if f := fieldByTag(v, name, false); f.IsValid() {
+		return f
+	}
+	return fieldByTag(v, name, true)
+}
+
+// fieldByTag implements fieldByName, matching the tag names, or the Go names if goNames is set.
+func fieldByTag(v reflect.Value, name string, goNames bool) reflect.Value {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, _, _ := strings.Cut(sf.Tag.Get("datastore"), ",")
+		if !sf.IsExported() || tag == "-" {
+			continue
+		}
+		if tag == "" && sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			if f := fieldByTag(v.Field(i), name, goNames); f.IsValid() {
+				return f
+			}
+			continue
+		}
+		if tag == "" || goNames {
+			tag = sf.Name
+		}
+		if tag == name {
+			return v.Field(i)
+		}
+		if rest, ok := strings.CutPrefix(name, tag+"."); ok && sf.Type.Kind() == reflect.Struct {
+			if f := fieldByTag(v.Field(i), rest, goNames); f.IsValid() {
+				return f
+			}
+		}
+	}
+	return reflect.Value{}
+}
+
 type setOp func(f *reflect.Value, value any)
 
 func setBool(f *reflect.Value, value any) {
@@ -240,6 +382,21 @@ func setString(f *reflect.Value, value any) {
 	}
 }
 
+func appendValue(f *reflect.Value, value any) {
+	if arr, ok := value.([]any); ok {
+		for _, e := range arr {
+			appendValue(f, e)
+		}
+		return
+	}
+	elem := f.Type().Elem()
+	if _, ok := value.(string); ok && elem.Kind() != reflect.String {
+		value = exportedValue(value, reflect.Zero(elem).Interface()) // e.g. a timestamp read from an export array
+	}
+	v := reflect.ValueOf(value).Convert(elem)
+	f.Set(reflect.Append(*f, v))
+}
+
 func setAny(f *reflect.Value, value any) {
 	v := reflect.ValueOf(value).Convert(f.Type())
 	f.Set(v)
@@ -1,9 +1,12 @@
 package dsio
 
 import (
+	"bytes"
 	"reflect"
 	"testing"
+	"time"
 
+	"cloud.google.com/go/datastore"
 	"github.com/stretchr/testify/require"
 )
 
@@ -58,6 +61,77 @@ func TestReflector(t *testing.T) {
 	require.Equal(t, "xxx", a.X) // should not be touched
 }
 
+func TestReflector_UnknownFieldPolicy(t *testing.T) {
+	r := NewReflector(&B{})
+	r.UnknownFieldPolicy = UnknownFieldIgnore
+	require.NoError(t, r.SetField("Y", 1))
+	require.NoError(t, r.SetField("X", "x"))
+	r.Set("W", 1)
+	require.Equal(t, []string{"W", "Y"}, r.UnknownFields())
+	r.UnknownFieldPolicy = UnknownFieldFail
+	require.EqualError(t, r.SetField("Y", 1), `Unknown field "Y" in dsio.B`)
+	require.NoError(t, r.SetField("X", "x"))
+	r.Set("Y", 1)
+}
+
+func TestImportStreamReflect_UnknownFields(t *testing.T) {
+	var in bytes.Buffer
+	enc := NewEncoder(&in)
+	require.NoError(t, enc.EncodeEntity(Entity{Key: datastore.IDKey("B", 1, nil), Properties: datastore.PropertyList{{Name: "X", Value: "a"}, {Name: "W", Value: int64(1)}}}))
+	require.NoError(t, enc.EncodeEntity(Entity{Key: datastore.IDKey("B", 2, nil), Properties: datastore.PropertyList{{Name: "V", Value: "b"}}}))
+	require.NoError(t, enc.Flush())
+	var rows []any
+	unknown := make(map[string][]string)
+	err := ImportStreamReflect(&in, []ModelMapping{{
+		Kind:               "B",
+		TypePtr:            &B{},
+		BatchSize:          10,
+		UnknownFieldPolicy: UnknownFieldIgnore,
+		ImportFunc: func(kind string, batch []any) error {
+			rows = append(rows, batch...)
+			return nil
+		},
+		UnknownFieldsFunc: func(kind string, names []string) { unknown[kind] = names },
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []any{&B{X: "a"}, &B{}}, rows)
+	require.Equal(t, map[string][]string{"B": {"V", "W"}}, unknown)
+}
+
+type PLS struct {
+	X   string
+	Key *datastore.Key
+}
+
+func (p *PLS) Load(props []datastore.Property) error {
+	for _, prop := range props {
+		if prop.Name == "Old" {
+			p.X = "migrated " + prop.Value.(string)
+		}
+	}
+	return nil
+}
+
+func (p *PLS) Save() ([]datastore.Property, error) {
+	return nil, nil
+}
+
+func (p *PLS) LoadKey(k *datastore.Key) error {
+	p.Key = k
+	return nil
+}
+
+func TestReflector_Load(t *testing.T) {
+	key := datastore.IDKey("A", 1, nil)
+	props := datastore.PropertyList{{Name: "X", Value: "xxx"}, {Name: "Old", Value: "yyy"}}
+	a, err := NewReflector(&A{}).Load(Entity{Key: key, Properties: props})
+	require.NoError(t, err)
+	require.Equal(t, "xxx", a.(*A).X)
+	p, err := NewReflector(&PLS{}).Load(Entity{Key: key, Properties: props})
+	require.NoError(t, err)
+	require.Equal(t, &PLS{X: "migrated yyy", Key: key}, p)
+}
+
 func BenchmarkBaseline(b *testing.B) {
 	ch := make(chan any, 128)
 	defer close(ch)
@@ -114,3 +188,81 @@ func sink(ch chan any) {
 	for range ch {
 	}
 }
+
+type Tagged struct {
+	Key       *datastore.Key `datastore:"__key__"`
+	CreatedAt int64          `datastore:"createdAt"`
+	Tags      []string       `datastore:"tags"`
+	Body      []byte         `datastore:"body,noindex"`
+	Address   TaggedAddress  `datastore:"address,flatten"`
+}
+
+type TaggedAddress struct {
+	City string `datastore:"city"`
+}
+
+func TestReflector_Tags(t *testing.T) {
+	r := NewReflector(&Tagged{})
+	r.UnknownFieldPolicy = UnknownFieldFail
+	key := datastore.NameKey("Tagged", "a", nil)
+	e := Entity{Key: key, Properties: datastore.PropertyList{
+		{Name: "createdAt", Value: int64(5)},
+		{Name: "tags", Value: "x"},
+		{Name: "tags", Value: "y"},
+		{Name: "body", Value: []byte{1}},
+		{Name: "address.city", Value: "Paris"},
+	}}
+	v1, err := r.Load(e)
+	require.NoError(t, err)
+	expected := &Tagged{Key: key, CreatedAt: 5, Tags: []string{"x", "y"}, Body: []byte{1}, Address: TaggedAddress{City: "Paris"}}
+	require.Equal(t, expected, v1)
+	v2, err := r.Load(Entity{Key: key, Properties: e.Properties[1:2]})
+	require.NoError(t, err)
+	require.Equal(t, &Tagged{Key: key, Tags: []string{"x"}}, v2)
+	require.Equal(t, expected, v1)
+
+	// the same struct round-trips through datastore.SaveStruct
+	props, err := datastore.SaveStruct(expected)
+	require.NoError(t, err)
+	require.Equal(t, "address.city", props[len(props)-1].Name)
+}
+
+type Renamed struct {
+	TaggedAddress
+	Name   string `datastore:"name"`
+	Secret string `datastore:"-"`
+}
+
+func TestReflector_TagNames(t *testing.T) {
+	r := NewReflector(&Renamed{})
+	r.UnknownFieldPolicy = UnknownFieldIgnore
+	r.Reset()
+	r.Set("name", "a")
+	r.Set("Secret", "c")
+	r.Set("city", "Paris")
+	require.Equal(t, &Renamed{TaggedAddress: TaggedAddress{City: "Paris"}, Name: "a"}, r.MakeCopy())
+	require.Equal(t, []string{"Secret"}, r.UnknownFields())
+
+	// Go names of tagged fields are still matched
+	r.Reset()
+	r.Set("Name", "b")
+	r.Set("City", "Rome")
+	require.Equal(t, &Renamed{TaggedAddress: TaggedAddress{City: "Rome"}, Name: "b"}, r.MakeCopy())
+}
+
+type Arrays struct {
+	K []*datastore.Key
+	T []time.Time
+}
+
+func TestReflector_untypedArrays(t *testing.T) {
+	key := datastore.NameKey("User", "ann", nil)
+	at := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	// array elements read from an export have no type
+	a, err := NewReflector(&Arrays{}).Load(Entity{Key: datastore.IDKey("A", 1, nil), Properties: datastore.PropertyList{
+		{Name: "K", Value: []any{key.Encode()}},
+		{Name: "T", Value: []any{at.Format(time.RFC3339Nano)}},
+	}})
+	require.NoError(t, err)
+	require.Equal(t, &Arrays{K: []*datastore.Key{key}, T: []time.Time{at}}, a)
+}
@@ -0,0 +1,152 @@
+package dsio
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// KindSchema describes the properties of a kind, inferred from the exported entities.
+type KindSchema struct {
+	Kind       string
+	Entities   int
+	Properties []*PropertySchema // sorted by name
+}
+
+// PropertySchema describes a property of a kind.
+type PropertySchema struct {
+	Name string
+	// Types lists the value types (as named by the type prefixes of ParseTypedValue), most frequent first.
+	Types    []string
+	Repeated bool // some entities have multiple values
+	NoIndex  bool
+	Optional bool // some entities don't have the property or only have a null value
+	counts   map[string]int
+	entities int
+	// elemTypes counts the types the string array elements look like, e.g. "time"; array elements have no type
+	// in export files, so timestamps and keys are read back as strings
+	elemTypes map[string]int
+}
+
+// ReadSchema infers the schema of each kind in an export stream, sorted by kind.
+func ReadSchema(r io.Reader) ([]*KindSchema, error) {
+	kinds := make(map[string]*KindSchema)
+	props := make(map[string]map[string]*PropertySchema)
+	err := readEntities(r, func(rec Entity) error {
+		ks := kinds[rec.Key.Kind]
+		if ks == nil {
+			ks = &KindSchema{Kind: rec.Key.Kind}
+			kinds[rec.Key.Kind] = ks
+			props[rec.Key.Kind] = make(map[string]*PropertySchema)
+		}
+		ks.Entities++
+		seen := make(map[string]int)
+		for _, p := range rec.Properties {
+			ps := props[ks.Kind][p.Name]
+			if ps == nil {
+				ps = &PropertySchema{Name: p.Name, counts: make(map[string]int), elemTypes: make(map[string]int)}
+				props[ks.Kind][p.Name] = ps
+			}
+			if p.Value == nil {
+				ps.counts["null"]++
+				continue
+			}
+			if seen[p.Name]++; seen[p.Name] == 1 {
+				ps.entities++
+			} else {
+				ps.Repeated = true
+			}
+			if arr, ok := p.Value.([]any); ok {
+				ps.Repeated = true
+				for _, v := range arr {
+					ps.counts[valueTypeName(v)]++
+					if s, ok := v.(string); ok {
+						ps.elemTypes[stringElemType(s)]++
+					}
+				}
+			} else {
+				ps.counts[valueTypeName(p.Value)]++
+			}
+			ps.NoIndex = ps.NoIndex || p.NoIndex
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res []*KindSchema
+	for _, kind := range slices.Sorted(maps.Keys(kinds)) {
+		ks := kinds[kind]
+		for _, name := range slices.Sorted(maps.Keys(props[kind])) {
+			ps := props[kind][name]
+			ps.Optional = ps.entities < ks.Entities
+			ps.Types = slices.SortedFunc(maps.Keys(ps.counts), func(a, b string) int {
+				if ps.counts[a] != ps.counts[b] {
+					return ps.counts[b] - ps.counts[a]
+				}
+				return strings.Compare(a, b)
+			})
+			ks.Properties = append(ks.Properties, ps)
+		}
+		res = append(res, ks)
+	}
+	return res, nil
+}
+
+// WriteSchema writes a human-readable description of the schema.
+func WriteSchema(w io.Writer, kinds []*KindSchema) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for i, ks := range kinds {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "Kind %s (%d entities)\n", ks.Kind, ks.Entities)
+		for _, ps := range ks.Properties {
+			var flags []string
+			if ps.Repeated {
+				flags = append(flags, "repeated")
+			}
+			if ps.NoIndex {
+				flags = append(flags, "noindex")
+			}
+			if ps.Optional {
+				flags = append(flags, "optional")
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", ps.Name, strings.Join(ps.Types, "|"), strings.Join(flags, ", "))
+		}
+	}
+	return tw.Flush()
+}
+
+// stringElemType returns the type a string array element read from an export looks like: time, key or string.
+func stringElemType(s string) string {
+	if _, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return "time"
+	}
+	if k, err := datastore.DecodeKey(s); err == nil && k.Kind != "" && !k.Incomplete() {
+		return "key"
+	}
+	return "string"
+}
+
+// arrayType returns the type of the elements of a repeated string property if they all look like
+// timestamps or keys (see stringElemType), otherwise "".
+func (ps *PropertySchema) arrayType() string {
+	for t := range ps.counts {
+		if t != "string" && t != "null" {
+			return ""
+		}
+	}
+	for _, t := range []string{"time", "key"} {
+		if n := ps.elemTypes[t]; n > 0 && n == ps.counts["string"] {
+			return t
+		}
+	}
+	return ""
+}
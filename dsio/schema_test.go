@@ -0,0 +1,134 @@
+package dsio
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSchema(t *testing.T) {
+	r := encodeEntities(t, nil,
+		Entity{Key: datastore.IDKey("user_event", 1, nil), Properties: datastore.PropertyList{
+			{Name: "createdAt", Value: time.Now()},
+			{Name: "tags", Value: []any{"a", "b"}},
+			{Name: "body", Value: "x", NoIndex: true},
+			{Name: "address.city", Value: "Paris"},
+			{Name: "address.geo.lat", Value: 1.5},
+		}},
+		Entity{Key: datastore.IDKey("user_event", 2, nil), Properties: datastore.PropertyList{
+			{Name: "createdAt", Value: time.Now()},
+			{Name: "tags", Value: []any{"c"}},
+			{Name: "owner", Value: int64(5)},
+		}},
+	)
+	kinds, err := ReadSchema(r)
+	require.NoError(t, err)
+	require.Len(t, kinds, 1)
+	assert.Equal(t, 2, kinds[0].Entities)
+
+	var sb strings.Builder
+	require.NoError(t, WriteSchema(&sb, kinds))
+	assert.Equal(t, `Kind user_event (2 entities)
+  address.city     string  optional
+  address.geo.lat  float   optional
+  body             string  noindex, optional
+  createdAt        time    
+  owner            int     optional
+  tags             string  repeated
+`, sb.String())
+
+	sb.Reset()
+	require.NoError(t, GenerateGo(&sb, "models", kinds))
+	assert.Equal(t, `// Code generated by dsutil gen-go. DO NOT EDIT.
+
+package models
+
+import (
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// UserEvent is the user_event kind.
+type UserEvent struct {
+	Key       *datastore.Key   `+"`"+`datastore:"__key__"`+"`"+`
+	Address   UserEventAddress `+"`"+`datastore:"address,flatten"`+"`"+`
+	Body      string           `+"`"+`datastore:"body,noindex"`+"`"+`
+	CreatedAt time.Time        `+"`"+`datastore:"createdAt"`+"`"+`
+	Owner     int64            `+"`"+`datastore:"owner"`+"`"+`
+	Tags      []string         `+"`"+`datastore:"tags"`+"`"+`
+}
+
+// UserEventAddress holds the UserEvent.address properties.
+type UserEventAddress struct {
+	City string              `+"`"+`datastore:"city"`+"`"+`
+	Geo  UserEventAddressGeo `+"`"+`datastore:"geo,flatten"`+"`"+`
+}
+
+// UserEventAddressGeo holds the UserEventAddress.geo properties.
+type UserEventAddressGeo struct {
+	Lat float64 `+"`"+`datastore:"lat"`+"`"+`
+}
+`, sb.String())
+}
+
+func TestGenerateGo_null(t *testing.T) {
+	kinds := []*KindSchema{{Kind: "A", Entities: 2, Properties: []*PropertySchema{
+		{Name: "n", Types: []string{"null", "int"}, Optional: true},
+		{Name: "x", Types: []string{"null"}, Optional: true},
+	}}}
+	var sb strings.Builder
+	require.NoError(t, GenerateGo(&sb, "models", kinds))
+	assert.Equal(t, `// Code generated by dsutil gen-go. DO NOT EDIT.
+
+package models
+
+import (
+	"cloud.google.com/go/datastore"
+)
+
+// A is the A kind.
+type A struct {
+	Key *datastore.Key `+"`"+`datastore:"__key__"`+"`"+`
+	N   int64          `+"`"+`datastore:"n"`+"`"+`
+	X   string         `+"`"+`datastore:"x"`+"`"+` // unknown type
+}
+`, sb.String())
+}
+
+func TestGenerateGo_arrays(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	require.NoError(t, exportEntities(sliceSource([]Entity{{Key: datastore.IDKey("A", 1, nil), Properties: datastore.PropertyList{
+		{Name: "k", Value: []any{datastore.NameKey("User", "ann", nil)}},
+		{Name: "s", Value: []any{"x", at.Format(time.RFC3339)}},
+		{Name: "t", Value: []any{at, at}},
+	}}}), &buf, nil))
+	kinds, err := ReadSchema(&buf)
+	require.NoError(t, err)
+	var sb strings.Builder
+	require.NoError(t, GenerateGo(&sb, "models", kinds))
+	assert.Equal(t, `// Code generated by dsutil gen-go. DO NOT EDIT.
+
+package models
+
+import (
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// A is the A kind.
+type A struct {
+	Key *datastore.Key   `+"`"+`datastore:"__key__"`+"`"+`
+	K   []*datastore.Key `+"`"+`datastore:"k"`+"`"+`
+	S   []string         `+"`"+`datastore:"s"`+"`"+`
+	T   []time.Time      `+"`"+`datastore:"t"`+"`"+`
+}
+`, sb.String())
+}
@@ -0,0 +1,86 @@
+package dsio
+
+import (
+	"bufio"
+	"os"
+)
+
+// entitySpill keeps entities by key in a temporary file (in NDJSON format), with only their locations in memory.
+// Adding an entity with the same key again replaces it.
+type entitySpill struct {
+	f      *os.File
+	wbuf   *bufio.Writer
+	offset int64
+	locs   map[string]spillLocation
+	order  []string // keys in the order of first appearance
+}
+
+type spillLocation struct {
+	offset int64
+	size   int
+}
+
+func newEntitySpill() (*entitySpill, error) {
+	f, err := os.CreateTemp("", "dsutil-*.ndjson")
+	if err != nil {
+		return nil, err
+	}
+	return &entitySpill{f: f, wbuf: bufio.NewWriterSize(f, 32768), locs: make(map[string]spillLocation)}, nil
+}
+
+// close removes the temporary file.
+func (s *entitySpill) close() {
+	s.f.Close()
+	os.Remove(s.f.Name())
+}
+
+func (s *entitySpill) add(rec Entity) error {
+	b, err := MarshalNDJSON(rec)
+	if err != nil {
+		return err
+	}
+	k := MarshalKey(rec.Key)
+	if _, ok := s.locs[k]; !ok {
+		s.order = append(s.order, k)
+	}
+	s.locs[k] = spillLocation{offset: s.offset, size: len(b)}
+	s.offset += int64(len(b))
+	_, err = s.wbuf.Write(b)
+	return err
+}
+
+// take returns and forgets the entity with the given key, if present.
+func (s *entitySpill) take(k string) (rec Entity, ok bool, err error) {
+	loc, ok := s.locs[k]
+	if !ok {
+		return rec, false, nil
+	}
+	delete(s.locs, k)
+	if s.wbuf.Buffered() > 0 {
+		if err = s.wbuf.Flush(); err != nil {
+			return rec, false, err
+		}
+	}
+	b := make([]byte, loc.size)
+	if _, err = s.f.ReadAt(b, loc.offset); err != nil {
+		return rec, false, err
+	}
+	rec, err = UnmarshalNDJSON(b)
+	return rec, err == nil, err
+}
+
+// rest calls fn for the entities not taken yet, in the order of first appearance.
+func (s *entitySpill) rest(fn func(Entity) error) error {
+	for _, k := range s.order {
+		rec, ok, err := s.take(k)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if err = fn(rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package dsio
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Stats describes the contents of an export stream.
+type Stats struct {
+	Entities   int
+	Namespaces map[string]int
+	Kinds      map[string]*KindStats
+	// Largest lists the largest entities by estimated storage size, largest first.
+	Largest []EntitySize
+	// StorageSize and IndexSize estimate the DataStore storage of the entities and of their built-in indexes.
+	StorageSize int64
+	IndexSize   int64
+}
+
+// KindStats describes the entities of a kind.
+type KindStats struct {
+	Entities   int
+	IDKeys     int
+	NameKeys   int
+	MinID      int64
+	MaxID      int64
+	Properties map[string]*PropertyStats
+}
+
+// PropertyStats describes the values of a property of a kind.
+type PropertyStats struct {
+	Entities int            // entities having the property (the export format doesn't keep null values)
+	Types    map[string]int // number of values by type
+	Min      any            `json:",omitempty"`
+	Max      any            `json:",omitempty"`
+}
+
+// EntitySize is the estimated storage size of an entity.
+type EntitySize struct {
+	Key  *datastore.Key
+	Size int64
+}
+
+const largestEntities = 10
+
+// ReadStats computes the statistics of an export stream in a single pass.
+func ReadStats(r io.Reader) (*Stats, error) {
+	s := &Stats{Namespaces: make(map[string]int), Kinds: make(map[string]*KindStats)}
+	err := readEntities(r, func(rec Entity) error {
+		s.add(rec)
+		return nil
+	})
+	return s, err
+}
+
+func (s *Stats) add(rec Entity) {
+	s.Entities++
+	s.Namespaces[rec.Key.Namespace]++
+	ks := s.Kinds[rec.Key.Kind]
+	if ks == nil {
+		ks = &KindStats{Properties: make(map[string]*PropertyStats)}
+		s.Kinds[rec.Key.Kind] = ks
+	}
+	ks.Entities++
+	if rec.Key.Name != "" {
+		ks.NameKeys++
+	} else {
+		if ks.IDKeys == 0 || rec.Key.ID < ks.MinID {
+			ks.MinID = rec.Key.ID
+		}
+		if ks.IDKeys == 0 || rec.Key.ID > ks.MaxID {
+			ks.MaxID = rec.Key.ID
+		}
+		ks.IDKeys++
+	}
+	keySize := keyStorageSize(rec.Key)
+	size := keySize + 32
+	seen := make(map[string]bool)
+	for _, p := range rec.Properties {
+		ps := ks.Properties[p.Name]
+		if ps == nil {
+			ps = &PropertyStats{Types: make(map[string]int)}
+			ks.Properties[p.Name] = ps
+		}
+		if !seen[p.Name] {
+			seen[p.Name] = true
+			ps.Entities++
+		}
+		ps.Types[valueTypeName(p.Value)]++
+		if _, ok := compareValues(p.Value, p.Value); ok { // ordered type
+			if c, _ := compareValues(p.Value, ps.Min); ps.Min == nil || c < 0 {
+				ps.Min = p.Value
+			}
+			if c, _ := compareValues(p.Value, ps.Max); ps.Max == nil || c > 0 {
+				ps.Max = p.Value
+			}
+		}
+		propSize := stringStorageSize(p.Name) + valueStorageSize(p.Value)
+		size += propSize
+		if !p.NoIndex {
+			// ascending and descending built-in index entries
+			s.IndexSize += 2 * (keySize + stringStorageSize(rec.Key.Kind) + propSize + 32)
+		}
+	}
+	s.StorageSize += size
+	s.addLargest(EntitySize{Key: rec.Key, Size: size})
+}
+
+func (s *Stats) addLargest(e EntitySize) {
+	if len(s.Largest) == largestEntities && e.Size <= s.Largest[len(s.Largest)-1].Size {
+		return
+	}
+	i := sort.Search(len(s.Largest), func(i int) bool { return s.Largest[i].Size < e.Size })
+	s.Largest = slices.Insert(s.Largest, i, e)
+	if len(s.Largest) > largestEntities {
+		s.Largest = s.Largest[:largestEntities]
+	}
+}
+
+// The storage size estimates follow https://cloud.google.com/datastore/docs/concepts/storage-size
+
+func stringStorageSize(s string) int64 {
+	return int64(len(s)) + 1
+}
+
+func keyStorageSize(key *datastore.Key) int64 {
+	size := int64(16) + stringStorageSize(key.Namespace)
+	for k := key; k != nil; k = k.Parent {
+		size += stringStorageSize(k.Kind)
+		if k.Name != "" {
+			size += stringStorageSize(k.Name)
+		} else {
+			size += 8
+		}
+	}
+	return size
+}
+
+func valueStorageSize(value any) int64 {
+	switch v := value.(type) {
+	case string:
+		return stringStorageSize(v)
+	case []byte:
+		return int64(len(v)) + 1
+	case *datastore.Key:
+		return keyStorageSize(v)
+	case int64, float64, time.Time:
+		return 8
+	case []any:
+		var size int64
+		for _, e := range v {
+			size += valueStorageSize(e)
+		}
+		return size
+	}
+	return 1 // bool and null
+}
+
+// valueTypeName returns the type name of a value, as used by the type prefixes of ParseTypedValue.
+func valueTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case int64:
+		return "int"
+	case float64:
+		return "float"
+	case bool:
+		return "bool"
+	case time.Time:
+		return "time"
+	case *datastore.Key:
+		return "key"
+	case []byte:
+		return "blob"
+	case []any:
+		return "array"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// WriteReport writes a human-readable report of the statistics.
+func (s *Stats) WriteReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Entities: %d\n", s.Entities)
+	fmt.Fprintf(tw, "Estimated storage size: %s (built-in indexes: %s)\n", formatSize(s.StorageSize), formatSize(s.IndexSize))
+	fmt.Fprintf(tw, "\nNamespaces:\n")
+	for _, ns := range slices.Sorted(maps.Keys(s.Namespaces)) {
+		name := ns
+		if name == "" {
+			name = "(default)"
+		}
+		fmt.Fprintf(tw, "  %s\t%d\n", name, s.Namespaces[ns])
+	}
+	for _, kind := range slices.Sorted(maps.Keys(s.Kinds)) {
+		ks := s.Kinds[kind]
+		fmt.Fprintf(tw, "\nKind %s: %d entities, %d name keys, %d ID keys", kind, ks.Entities, ks.NameKeys, ks.IDKeys)
+		if ks.IDKeys > 0 {
+			fmt.Fprintf(tw, " (%d..%d)", ks.MinID, ks.MaxID)
+		}
+		fmt.Fprintf(tw, "\n  Property\tTypes\tNull/missing\tMin\tMax\n")
+		for _, name := range slices.Sorted(maps.Keys(ks.Properties)) {
+			ps := ks.Properties[name]
+			var types []string
+			for _, t := range slices.Sorted(maps.Keys(ps.Types)) {
+				types = append(types, fmt.Sprintf("%s:%d", t, ps.Types[t]))
+			}
+			missing := float64(ks.Entities-ps.Entities) * 100 / float64(ks.Entities)
+			fmt.Fprintf(tw, "  %s\t%v\t%.1f%%\t%s\t%s\n", name, types, missing, formatStatsValue(ps.Min), formatStatsValue(ps.Max))
+		}
+	}
+	fmt.Fprintf(tw, "\nLargest entities:\n")
+	for _, e := range s.Largest {
+		fmt.Fprintf(tw, "  %s\t%s\n", MarshalKey(e.Key), formatSize(e.Size))
+	}
+	return tw.Flush()
+}
+
+func formatStatsValue(value any) string {
+	if value == nil {
+		return ""
+	}
+	s := formatGQLValue(value)
+	if len(s) > 40 {
+		s = s[:37] + "..."
+	}
+	return s
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,43 @@
+package dsio
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadStats(t *testing.T) {
+	ns := datastore.NameKey("Doc", "x", nil)
+	ns.Namespace = "ns"
+	r := encodeEntities(t, nil,
+		Entity{Key: datastore.IDKey("Event", 5, nil), Properties: datastore.PropertyList{{Name: "N", Value: int64(3)}, {Name: "S", Value: "b"}}},
+		Entity{Key: datastore.IDKey("Event", 2, nil), Properties: datastore.PropertyList{{Name: "N", Value: int64(7)}}},
+		Entity{Key: datastore.IDKey("Event", 9, nil), Properties: datastore.PropertyList{{Name: "N", Value: int64(-1)}, {Name: "S", Value: "a"}}},
+		Entity{Key: ns, Properties: datastore.PropertyList{{Name: "Body", Value: strings.Repeat("x", 100), NoIndex: true}}},
+	)
+	s, err := ReadStats(r)
+	require.NoError(t, err)
+	assert.Equal(t, 4, s.Entities)
+	assert.Equal(t, map[string]int{"": 3, "ns": 1}, s.Namespaces)
+
+	ev := s.Kinds["Event"]
+	assert.Equal(t, &KindStats{Entities: 3, IDKeys: 3, MinID: 2, MaxID: 9, Properties: map[string]*PropertyStats{
+		"N": {Entities: 3, Types: map[string]int{"int": 3}, Min: int64(-1), Max: int64(7)},
+		"S": {Entities: 2, Types: map[string]int{"string": 2}, Min: "a", Max: "b"},
+	}}, ev)
+	assert.Equal(t, 1, s.Kinds["Doc"].NameKeys)
+
+	require.Len(t, s.Largest, 4)
+	assert.Equal(t, ns, s.Largest[0].Key)
+	// key: 16 + ns 3 + kind 4 + name 2; property: name 5 + value 101; entity overhead 32
+	assert.Equal(t, int64(25+106+32), s.Largest[0].Size)
+	assert.Greater(t, s.IndexSize, int64(0))
+
+	var sb strings.Builder
+	require.NoError(t, s.WriteReport(&sb))
+	assert.Contains(t, sb.String(), "Kind Event: 3 entities, 0 name keys, 3 ID keys (2..9)")
+	assert.Contains(t, sb.String(), "33.3%")
+}
@@ -0,0 +1,108 @@
+package dsio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// A minimal encoder of the Thrift compact protocol, as used by the Parquet file metadata.
+
+// thriftStruct is a Thrift struct, its fields in increasing id order.
+type thriftStruct []thriftField
+
+// thriftField is a struct field, whose value is an int32, int64, string, bool, thriftStruct or thriftList.
+type thriftField struct {
+	id    int16
+	value any
+}
+
+// thriftList is a list of elements of the given compact type.
+type thriftList struct {
+	elemType byte
+	elems    []any
+}
+
+const (
+	thriftTypeTrue   = 1
+	thriftTypeFalse  = 2
+	thriftTypeI32    = 5
+	thriftTypeI64    = 6
+	thriftTypeBinary = 8
+	thriftTypeList   = 9
+	thriftTypeStruct = 12
+)
+
+func (s thriftStruct) encode(b *bytes.Buffer) error {
+	var last int16
+	for _, f := range s {
+		typ, err := thriftTypeOf(f.value)
+		if err != nil {
+			return err
+		}
+		if d := f.id - last; d > 0 && d <= 15 {
+			b.WriteByte(byte(d)<<4 | typ)
+		} else {
+			b.WriteByte(typ)
+			b.Write(binary.AppendVarint(nil, int64(f.id)))
+		}
+		last = f.id
+		if _, ok := f.value.(bool); !ok { // booleans are encoded in the field type
+			if err := thriftEncodeValue(b, f.value); err != nil {
+				return err
+			}
+		}
+	}
+	b.WriteByte(0) // stop
+	return nil
+}
+
+func thriftTypeOf(value any) (byte, error) {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return thriftTypeTrue, nil
+		}
+		return thriftTypeFalse, nil
+	case int32:
+		return thriftTypeI32, nil
+	case int64:
+		return thriftTypeI64, nil
+	case string:
+		return thriftTypeBinary, nil
+	case thriftList:
+		return thriftTypeList, nil
+	case thriftStruct:
+		return thriftTypeStruct, nil
+	}
+	return 0, fmt.Errorf("Unsupported Thrift value type %T", value)
+}
+
+func thriftEncodeValue(b *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case int32:
+		b.Write(binary.AppendVarint(nil, int64(v)))
+	case int64:
+		b.Write(binary.AppendVarint(nil, v))
+	case string:
+		b.Write(binary.AppendUvarint(nil, uint64(len(v))))
+		b.WriteString(v)
+	case thriftList:
+		if len(v.elems) < 15 {
+			b.WriteByte(byte(len(v.elems))<<4 | v.elemType)
+		} else {
+			b.WriteByte(0xf0 | v.elemType)
+			b.Write(binary.AppendUvarint(nil, uint64(len(v.elems))))
+		}
+		for _, e := range v.elems {
+			if err := thriftEncodeValue(b, e); err != nil {
+				return err
+			}
+		}
+	case thriftStruct:
+		return v.encode(b)
+	default:
+		return fmt.Errorf("Unsupported Thrift value type %T", value)
+	}
+	return nil
+}
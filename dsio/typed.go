@@ -0,0 +1,135 @@
+package dsio
+
+import (
+	"fmt"
+	"io"
+)
+
+// TypedImportFunc is the type of the typed import callback.
+type TypedImportFunc[T any] func(rows []*T) error
+
+// Registry maps DataStore kinds to typed import callbacks.
+// Unlike ModelMapping, each registered kind is compile-time checked.
+type Registry struct {
+	// UnknownFieldPolicy defines how properties without a matching struct field are handled.
+	UnknownFieldPolicy UnknownFieldPolicy
+	kinds              map[string]typedSink
+	order              []string
+}
+
+type typedSink interface {
+	add(e Entity) error
+	flush() error
+	getReflector() *Reflector
+}
+
+type typedKind[T any] struct {
+	reflector  *Reflector
+	batchSize  int
+	importFunc TypedImportFunc[T]
+	rows       []*T
+}
+
+// NewRegistry returns a new empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{kinds: make(map[string]typedSink)}
+}
+
+// Register adds a kind to the registry; importFunc will be called with batches of up to batchSize rows.
+// Registering the same kind twice replaces the previous registration.
+func Register[T any](reg *Registry, kind string, batchSize int, importFunc TypedImportFunc[T]) {
+	if _, ok := reg.kinds[kind]; !ok {
+		reg.order = append(reg.order, kind)
+	}
+	reg.kinds[kind] = &typedKind[T]{
+		reflector:  NewReflector(new(T)),
+		batchSize:  batchSize,
+		importFunc: importFunc,
+	}
+}
+
+// ImportFile imports a given .ds file using the registered kinds.
+func (reg *Registry) ImportFile(filename string) error {
+	infile, err := OpenForReading(filename)
+	if err != nil {
+		return err
+	}
+	defer infile.Close()
+	return reg.ImportStream(infile)
+}
+
+// ImportStream imports a given .ds stream using the registered kinds.
+// Returns an error if the stream contains a kind that is not registered.
+func (reg *Registry) ImportStream(r io.Reader) (err error) {
+	for _, sink := range reg.kinds {
+		sink.getReflector().UnknownFieldPolicy = reg.UnknownFieldPolicy
+	}
+	outCh := make(chan Entity, 10)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		for e := range outCh {
+			sink, ok := reg.kinds[e.Key.Kind]
+			if !ok {
+				errCh <- fmt.Errorf("Unknown type %q", e.Key.Kind)
+				return
+			}
+			if err := sink.add(e); err != nil {
+				errCh <- err
+				return
+			}
+		}
+		for _, kind := range reg.order {
+			if err := reg.kinds[kind].flush(); err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+	err = ImportFile(r, outCh, errCh)
+	return
+}
+
+// UnknownFields returns the names of unknown fields seen so far, per kind.
+// Kinds without unknown fields are omitted.
+func (reg *Registry) UnknownFields() map[string][]string {
+	res := make(map[string][]string)
+	for kind, sink := range reg.kinds {
+		if names := sink.getReflector().UnknownFields(); len(names) > 0 {
+			res[kind] = names
+		}
+	}
+	return res
+}
+
+func (k *typedKind[T]) getReflector() *Reflector {
+	return k.reflector
+}
+
+func (k *typedKind[T]) add(e Entity) error {
+	row, err := k.reflector.Load(e)
+	if err != nil {
+		return err
+	}
+	k.rows = append(k.rows, row.(*T))
+	if len(k.rows) >= k.batchSize {
+		return k.flush()
+	}
+	return nil
+}
+
+func (k *typedKind[T]) flush() error {
+	if len(k.rows) == 0 {
+		return nil
+	}
+	rows := k.rows
+	k.rows = nil
+	return k.importFunc(rows)
+}
+
+// ImportTyped imports entities of a single kind from a given .ds stream into values of type T.
+func ImportTyped[T any](r io.Reader, kind string, batchSize int, importFunc TypedImportFunc[T]) error {
+	reg := NewRegistry()
+	Register(reg, kind, batchSize, importFunc)
+	return reg.ImportStream(r)
+}
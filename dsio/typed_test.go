@@ -0,0 +1,64 @@
+package dsio
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const typedTestStream = `{"FieldsFrom":0,"Fields":[{"n":"X","t":"string","i":false},{"n":"P","t":"int64","i":false}]}
+{"k":"/A,1","d":["a1",1]}
+{"k":"/B,2","d":["b2"]}
+{"k":"/A,3","d":["a3",3]}
+`
+
+func TestImportTyped(t *testing.T) {
+	var batches [][]*A
+	err := ImportTyped(strings.NewReader(strings.ReplaceAll(typedTestStream, "/B,2", "/A,2")), "A", 2, func(rows []*A) error {
+		batches = append(batches, rows)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, batches, 2)
+	require.Len(t, batches[0], 2)
+	require.Equal(t, "a1", batches[0][0].X)
+	require.Equal(t, 1, batches[0][0].P)
+	require.Equal(t, "b2", batches[0][1].X)
+	require.Equal(t, 3, batches[1][0].P)
+}
+
+func TestImportTyped_unknownKind(t *testing.T) {
+	err := ImportTyped(strings.NewReader(typedTestStream), "A", 10, func(rows []*A) error { return nil })
+	require.EqualError(t, err, `Unknown type "B"`)
+}
+
+func TestRegistry(t *testing.T) {
+	var as []*A
+	var bs []*B
+	reg := NewRegistry()
+	Register(reg, "A", 10, func(rows []*A) error {
+		as = append(as, rows...)
+		return nil
+	})
+	Register(reg, "B", 10, func(rows []*B) error {
+		bs = append(bs, rows...)
+		return nil
+	})
+	require.NoError(t, reg.ImportStream(strings.NewReader(typedTestStream)))
+	require.Len(t, as, 2)
+	require.Equal(t, "a3", as[1].X)
+	require.Len(t, bs, 1)
+	require.Equal(t, "b2", bs[0].X)
+}
+
+func TestRegistry_UnknownFields(t *testing.T) {
+	reg := NewRegistry()
+	reg.UnknownFieldPolicy = UnknownFieldIgnore
+	Register(reg, "A", 10, func(rows []*B) error { return nil })
+	Register(reg, "B", 10, func(rows []*B) error { return nil })
+	require.NoError(t, reg.ImportStream(strings.NewReader(typedTestStream)))
+	require.Equal(t, map[string][]string{"A": {"P"}}, reg.UnknownFields())
+	reg.UnknownFieldPolicy = UnknownFieldFail
+	require.EqualError(t, reg.ImportStream(strings.NewReader(typedTestStream)), `Unknown field "P" in dsio.B`)
+}
@@ -0,0 +1,160 @@
+package dsio
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+var typePrefixes = []string{"string", "int", "float", "double", "bool", "time", "key", "blob"}
+
+// splitTypePrefix splits a "type:value" literal, returning an empty type if there is no known type prefix.
+func splitTypePrefix(s string) (typ, value string) {
+	for _, p := range typePrefixes {
+		if len(s) > len(p) && s[len(p)] == ':' && strings.EqualFold(s[:len(p)], p) {
+			return p, s[len(p)+1:]
+		}
+	}
+	return "", s
+}
+
+// HasTypePrefix reports whether a literal has an explicit type prefix, e.g. "int:30".
+func HasTypePrefix(s string) bool {
+	typ, _ := splitTypePrefix(s)
+	return typ != ""
+}
+
+// ParseTypedValue parses a literal with an optional type prefix:
+// string:, int:, float: (or double:), bool:, time: (RFC 3339), key: (any notation supported by ParseAnyKey)
+// or blob: (base64).
+// Without a prefix, the value is interpreted according to the given DataStore property representations
+// (as returned by PropertyRepresentations), falling back to string.
+func ParseTypedValue(s string, representations []string) (any, error) {
+	typ, value := splitTypePrefix(s)
+	if typ != "" {
+		return parseValueAs(typ, value)
+	}
+	for _, r := range representations {
+		var v any
+		var err error
+		switch r {
+		case "BOOLEAN":
+			v, err = parseValueAs("bool", s)
+		case "INT64": // also used for timestamps
+			if v, err = parseValueAs("int", s); err != nil {
+				v, err = parseValueAs("time", s)
+			}
+		case "DOUBLE":
+			v, err = parseValueAs("float", s)
+		case "REFERENCE":
+			v, err = parseValueAs("key", s)
+		case "STRING":
+			return s, nil
+		default:
+			continue
+		}
+		if err == nil {
+			return v, nil
+		}
+	}
+	return s, nil
+}
+
+func parseValueAs(typ, s string) (v any, err error) {
+	switch typ {
+	case "string":
+		return s, nil
+	case "int":
+		v, err = strconv.ParseInt(s, 10, 64)
+	case "float", "double":
+		v, err = strconv.ParseFloat(s, 64)
+	case "bool":
+		v, err = strconv.ParseBool(s)
+	case "time":
+		v, err = time.Parse(time.RFC3339Nano, s)
+	case "key":
+		return ParseAnyKey(s)
+	case "blob":
+		v, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse '%s' as %s", s, typ)
+	}
+	return v, nil
+}
+
+// FormatTypedValue returns a value as a literal with a type prefix, as accepted by ParseTypedValue.
+func FormatTypedValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return "string:" + v, nil
+	case int64:
+		return "int:" + strconv.FormatInt(v, 10), nil
+	case float64:
+		return "float:" + strconv.FormatFloat(v, 'g', -1, 64), nil
+	case bool:
+		return "bool:" + strconv.FormatBool(v), nil
+	case time.Time:
+		return "time:" + v.UTC().Format(time.RFC3339Nano), nil
+	case *datastore.Key:
+		return "key:" + MarshalKey(v), nil
+	case []byte:
+		return "blob:" + base64.StdEncoding.EncodeToString(v), nil
+	}
+	return "", fmt.Errorf("Unsupported data type '%T'", value)
+}
+
+// compareValues compares two values of the same ordered type (string, int64, float64 or time.Time).
+// Returns false if the values can't be compared.
+func compareValues(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case int64:
+		if b, ok := b.(int64); ok {
+			return cmp.Compare(a, b), true
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			return cmp.Compare(a, b), true
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b), true
+		}
+	}
+	return 0, false
+}
+
+// PropertyRepresentations returns the property representations (e.g. "INT64", "STRING")
+// of the given kind by property name, using the __property__ metadata.
+func PropertyRepresentations(ds *datastore.Client, namespace, kind string) (map[string][]string, error) {
+	kindKey := datastore.NameKey("__kind__", kind, nil)
+	kindKey.Namespace = namespace
+	q := datastore.NewQuery("__property__").Namespace(namespace).Ancestor(kindKey)
+	it := ds.Run(context.Background(), q)
+	res := make(map[string][]string)
+	for {
+		var p struct {
+			Representation []string `datastore:"property_representation"`
+		}
+		key, err := it.Next(&p)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		res[key.Name] = append(res[key.Name], p.Representation...)
+	}
+	return res, nil
+}
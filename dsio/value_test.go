@@ -0,0 +1,42 @@
+package dsio
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTypedValue(t *testing.T) {
+	dt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		in    string
+		repr  []string
+		value any
+	}{
+		{"abc", nil, "abc"},
+		{"30", nil, "30"},
+		{"int:30", nil, int64(30)},
+		{"float:1.5", nil, 1.5},
+		{"double:2", nil, 2.0},
+		{"bool:true", nil, true},
+		{"string:int:30", nil, "int:30"},
+		{"time:2024-01-01T00:00:00Z", nil, dt},
+		{"key:/User,5", nil, datastore.IDKey("User", 5, nil)},
+		{"30", []string{"INT64"}, int64(30)},
+		{"2024-01-01T00:00:00Z", []string{"INT64"}, dt},
+		{"true", []string{"BOOLEAN"}, true},
+		{"1.5", []string{"DOUBLE"}, 1.5},
+		{"KEY(User, 5)", []string{"REFERENCE"}, datastore.IDKey("User", 5, nil)},
+		{"x", []string{"INT64", "STRING"}, "x"},
+		{"5", []string{"STRING", "INT64"}, "5"},
+		{"maybe", []string{"BOOLEAN"}, "maybe"},
+	} {
+		v, err := ParseTypedValue(tc.in, tc.repr)
+		require.NoError(t, err, tc.in)
+		require.Equal(t, tc.value, v, tc.in)
+	}
+	_, err := ParseTypedValue("int:x", nil)
+	require.EqualError(t, err, "Unable to parse 'x' as int")
+}
@@ -0,0 +1,120 @@
+package dsio
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Mismatch describes an exported entity that is missing or different in DataStore.
+type Mismatch struct {
+	Key     *datastore.Key
+	Missing bool
+	// Properties lists the different properties, Old being the exported values and New the values in DataStore.
+	Properties []PropertyChange
+}
+
+// String returns a human-readable description of the mismatch.
+func (m *Mismatch) String() string {
+	if m.Missing {
+		return "Missing " + MarshalKey(m.Key) + "\n"
+	}
+	var sb strings.Builder
+	sb.WriteString("Mismatch " + MarshalKey(m.Key) + "\n")
+	for _, p := range m.Properties {
+		fmt.Fprintf(&sb, "    %s: %s in file, %s in DataStore\n", p.Name, formatValues(p.Old), formatValues(p.New))
+	}
+	return sb.String()
+}
+
+// Verify compares the entities of an export stream with DataStore, fetching them in batches,
+// and calls fn for each entity that is missing or different.
+// Values must have the same type, except for array elements, which have no type in the export format
+// and are converted to the type of the DataStore values (see exportedValue); timestamps are compared at the millisecond precision of the export format
+// and null values are treated as missing, since the export format doesn't keep them.
+// Only the exported properties of partial exports are compared.
+// Returns the number of verified entities.
+func Verify(ds *datastore.Client, r io.Reader, fn func(m *Mismatch) error) (count int, err error) {
+	meta, r, err := ReadMetadata(r)
+	if err != nil {
+		return 0, err
+	}
+	var fields map[string]bool
+	if meta != nil && meta.Partial {
+		fields = make(map[string]bool)
+		for _, f := range meta.Fields {
+			fields[f] = true
+		}
+	}
+	var batch []Entity
+	batchSize := 200
+	flush := func() error {
+		keys := make([]*datastore.Key, len(batch))
+		for i, rec := range batch {
+			keys[i] = rec.Key
+		}
+		found, _, err := GetEntities(ds, keys)
+		if err != nil {
+			return err
+		}
+		current := make(map[string]datastore.PropertyList)
+		for _, rec := range found {
+			current[MarshalKey(rec.Key)] = rec.Properties
+		}
+		for _, rec := range batch {
+			props, ok := current[MarshalKey(rec.Key)]
+			var m *Mismatch
+			if !ok {
+				m = &Mismatch{Key: rec.Key, Missing: true}
+			} else if changed := diffPropertiesFunc(typedArrays(rec.Properties, props), comparableProperties(props, fields), exportedEqual); len(changed) > 0 {
+				m = &Mismatch{Key: rec.Key, Properties: changed}
+			}
+			if m != nil {
+				if err = fn(m); err != nil {
+					return err
+				}
+			}
+		}
+		count += len(batch)
+		batch = nil
+		return nil
+	}
+	err = readEntities(r, func(rec Entity) error {
+		batch = append(batch, rec)
+		if len(batch) >= batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+	return count, err
+}
+
+// comparableProperties returns the non-null properties, only the given fields if not nil.
+func comparableProperties(props datastore.PropertyList, fields map[string]bool) datastore.PropertyList {
+	var res datastore.PropertyList
+	for _, p := range props {
+		if p.Value != nil && (fields == nil || fields[p.Name]) {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// typedArrays returns props with the array elements converted to the types of the values
+// of the same property in like (see exportedValues).
+func typedArrays(props, like datastore.PropertyList) datastore.PropertyList {
+	values, _ := groupProperties(like)
+	res := make(datastore.PropertyList, len(props))
+	for i, p := range props {
+		if arr, ok := p.Value.([]any); ok {
+			p.Value = exportedValues(arr, values[p.Name])
+		}
+		res[i] = p
+	}
+	return res
+}
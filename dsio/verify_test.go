@@ -0,0 +1,57 @@
+package dsio
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportedEqual(t *testing.T) {
+	dt := time.Date(2024, 5, 1, 0, 0, 0, 123456000, time.UTC)
+	assert.True(t, exportedEqual(dt.Truncate(time.Millisecond), dt))
+	assert.False(t, exportedEqual(dt.Truncate(time.Second), dt))
+	assert.False(t, exportedEqual(int64(1), 1.0))
+	assert.True(t, exportedEqual([]byte{1}, []byte{1}))
+}
+
+func TestVerifyCompare(t *testing.T) {
+	key := datastore.IDKey("Test", 1, nil)
+	exported := datastore.PropertyList{{Name: "A", Value: "a"}, {Name: "N", Value: int64(1)}}
+	current := datastore.PropertyList{{Name: "A", Value: "a"}, {Name: "N", Value: 1.0}, {Name: "Z", Value: nil}, {Name: "X", Value: "x"}}
+
+	changed := diffPropertiesFunc(exported, comparableProperties(current, nil), exportedEqual)
+	m := &Mismatch{Key: key, Properties: changed}
+	assert.Equal(t, "Mismatch /Test,1\n"+
+		"    N: 1 in file, 1.0 in DataStore\n"+
+		"    X: (missing) in file, 'x' in DataStore\n", m.String())
+
+	changed = diffPropertiesFunc(exported[:1], comparableProperties(current, map[string]bool{"A": true}), exportedEqual)
+	assert.Empty(t, changed)
+
+	assert.Equal(t, "Missing /Test,1\n", (&Mismatch{Key: key, Missing: true}).String())
+}
+
+func TestVerifyCompare_arrays(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 30, 0, 123456789, time.UTC)
+	current := datastore.PropertyList{
+		{Name: "T", Value: []any{at}},
+		{Name: "K", Value: []any{datastore.NameKey("User", "ann", nil)}},
+		{Name: "F", Value: []any{2.0, 2.5}},
+		{Name: "B", Value: []any{[]byte{1, 2}}},
+	}
+	// array elements read back from an export have no type
+	exported := datastore.PropertyList{
+		{Name: "B", Value: []any{"AQI="}},
+		{Name: "F", Value: []any{int64(2), 2.5}},
+		{Name: "K", Value: []any{"EgsKBFVzZXIaA2Fubg"}},
+		{Name: "T", Value: []any{"2024-05-01T10:30:00.123456789Z"}},
+	}
+
+	assert.Empty(t, diffPropertiesFunc(typedArrays(exported, current), comparableProperties(current, nil), exportedEqual))
+	changed := diffPropertiesFunc(typedArrays(exported, current), datastore.PropertyList{{Name: "T", Value: []any{at.Add(time.Second)}}}, exportedEqual)
+	require.NotEmpty(t, changed)
+	assert.Equal(t, "T", changed[len(changed)-1].Name)
+}
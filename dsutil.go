@@ -3,9 +3,11 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	_ "net/http/pprof"
@@ -14,8 +16,10 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/datastore"
@@ -25,17 +29,59 @@ import (
 
 var (
 	project     = flag.String("project", "", "Google Cloud project name (deduced if not provided)")
-	kind        = flag.String("kind", "", "DataStore table name (required for 'export')")
-	filter      = flag.String("filter", "", "Filter field name (optional)")
+	kind        = flag.String("kind", "", "DataStore table name (required for 'export'; with 'import', the kind of CSV/TSV files)")
+	filter      = flag.String("filter", "", "Filter field name, or filter expression such as \"a >= 1 AND (b = 'x' OR c IN (1, 2))\" (optional)")
 	from        = flag.String("from", "", "Filter >= value (optional)")
-	to          = flag.String("to", "", "Filter < value (optional)")
+	to          = flag.String("to", "", "Filter < value (optional); with 'convert', the output format: go (default), csv, tsv, ndjson, parquet or postgres")
 	eq          = flag.String("eq", "", "Filter = value (optional)")
 	order       = flag.String("order", "", "Order by field name, use '-' prefix for descending order (optional)")
-	limit       = flag.Int("limit", 0, "Max number of records to export (optional)")
+	limit       = flag.Int("limit", 0, "Max number of records to process (optional)")
+	dryrun      = flag.Bool("dry-run", false, "Print the resolved query and the number of matching records, without exporting, updating or deleting; with 'apply', only check for conflicts (optional)")
+	ancestor    = flag.String("ancestor", "", "Ancestor key, -kind may be omitted to include all kinds (optional)")
+	gql         = flag.String("gql", "", "GQL query, e.g. \"SELECT * FROM Order WHERE status = 'open'\" (optional, replaces -kind, -filter and -ancestor)")
+	keys        = flag.String("keys", "", "File with keys to export or delete, one per line (optional)")
+	fields      = flag.String("fields", "", "Comma-separated list of properties to export, e.g. -fields a,b,c (optional, produces a partial export)")
+	merge       = flag.Bool("merge", false, "Import by merging the properties in the file into the existing entities, instead of replacing them (optional)")
+	sincefield  = flag.String("since-field", "", "Export only entities whose given property changed since the previous export recorded in -state (optional)")
+	statefile   = flag.String("state", "", "State file of incremental exports with -since-field, updated after each export")
+	readtime    = flag.String("read-time", "", "Export a consistent snapshot as of the given RFC 3339 timestamp or 'now' (optional)")
+	delfields   = flag.String("delete-fields", "", "Comma-separated list of properties to remove from the imported entities (optional, requires -merge)")
+	format      = flag.String("format", "", "Output format of 'diff', 'stats' and 'schema': text (default) or json; 'diff' also supports ds and ndjson (a patch with the old and new values of the changes); 'export' and 'convert' support ndjson and parquet")
+	pkgname     = flag.String("package", "models", "Package name of the code generated by 'gen-go'")
+	pgarrays    = flag.String("pg-arrays", "array", "Column type of repeated properties with 'convert -to postgres': array or jsonb")
+	pgnested    = flag.String("pg-nested", "columns", "Storage of embedded entities with 'convert -to postgres': columns (one per dotted property) or jsonb")
+	pgkeys      = flag.String("pg-keys", "text", "Key columns with 'convert -to postgres': text (__key__ only) or columns (also namespace, parent, ID and name)")
+	rowgroup    = flag.Int("row-group-size", 100000, "Number of rows per row group of Parquet files written by 'export' and 'convert'")
 	skipdefault = flag.Bool("skipdefault", false, "skip default values (in 'convert' command)")
 	// httpPort    = flag.Int("pprof", 0, "pprof listen port (e.g. 8080)") // for debugging
 )
 
+// bindingFlags collects -bind name=value options.
+type bindingFlags map[string]any
+
+func (b bindingFlags) String() string {
+	return ""
+}
+
+func (b bindingFlags) Set(s string) error {
+	name, literal, ok := strings.Cut(s, "=")
+	if !ok {
+		return errors.New("expected name=value")
+	}
+	value, err := dsio.ParseTypedValue(literal, nil)
+	if err != nil {
+		return err
+	}
+	b[strings.TrimPrefix(name, "@")] = value
+	return nil
+}
+
+var bindings = bindingFlags{}
+
+func init() {
+	flag.Var(bindings, "bind", "GQL query binding name=value, value can have a type prefix, e.g. -bind limit=int:10 (repeatable)")
+}
+
 func main() {
 	flag.Parse()
 	// if *httpPort > 0 {
@@ -61,6 +107,27 @@ func main() {
 	case "convert":
 		cmdConvert()
 		return
+	case "key":
+		cmdKey()
+		return
+	case "diff":
+		cmdDiff()
+		return
+	case "apply":
+		cmdApply()
+		return
+	case "verify":
+		cmdVerify()
+		return
+	case "stats":
+		cmdStats()
+		return
+	case "schema", "gen-go":
+		cmdSchema(cmd)
+		return
+	case "merge-incremental":
+		cmdMergeIncremental()
+		return
 	case "test":
 		cmdTest()
 	default:
@@ -72,11 +139,49 @@ func printUsageAndDie(msg string) {
 	fmt.Println(msg + `Usage: dsutil [options] command <args>
   command:
     export <filename>          - export records from DataStore
-    import <filename>...       - import records into DataStore
-    delete                     - delete records from DataStore
+    import <filename>...       - import records into DataStore (from export, .csv or .tsv files)
+    delete [<filename>...]     - delete records from DataStore (by query, -keys or keys in export file(s))
     set <field> <type> <value> - update records in DataStore (type is: string, int, double)
-    convert <in> <out>         - convert exported records from JSON to Go object notation
+    convert <in> <out>         - convert exported records from JSON to Go object notation,
+                                 or to CSV/TSV if <out> ends with .csv or .tsv (or with -to csv or -to tsv),
+                                 or to NDJSON if <out> ends with .ndjson or .jsonl (or with -to ndjson),
+                                 or to Parquet if <out> ends with .parquet (or with -to parquet),
+                                 or with -to postgres to a SQL script for psql (CREATE TABLE and COPY)
+    key <key>...               - print a key in dsutil, URL-safe encoded and GQL notations
+    diff <a> <b>               - report entities added, removed and modified between two export files
+    apply <patch>              - apply a patch written by diff -format json, ds or ndjson to DataStore
+    verify <filename>...       - check that the records in export file(s) are present and equal in DataStore
+    stats <filename>           - report statistics of an export file
+    schema <filename>          - report the properties and types of each kind in an export file
+    gen-go <filename> [<out>]  - generate Go structs for the kinds in an export file
+    merge-incremental <base> <inc>... <out>
+                               - merge incremental exports (oldest first) into a base export
   Note: <filename> ending with ".gz" will be automatically g(un)zipped
+  Note: <key> can be given in any of these notations: /Kind,123 or KEY(Kind, 123) or URL-safe encoded
+  Note: filter values can have a type prefix (string:, int:, float:, bool:, time:, key:), e.g. -filter "age>=int:30";
+        without a prefix the type is inferred from the -kind schema
+  Note: filter expressions support =, !=, <, <=, >, >=, IN, NOT IN, AND, OR, parentheses,
+        'quoted strings', KEY(Kind, 123) literals and NULL
+  Note: exports with -fields are partial and can only be imported with -merge
+  Note: diff exits with status 1 if the files differ, like diff(1)
+  Note: diff -format ds or ndjson writes each change as an entity with an __op__ property (add, remove or modify),
+        the new values of the changed properties and their old values as __old__.<name>; apply reads such patches, import refuses them
+  Note: apply only changes entities that match the patch's old values, other entities are reported as conflicts
+  Note: convert to CSV/TSV or postgres reads the input file twice, to infer the schema and to copy the data;
+        use - as <out> to write to stdout, e.g. to pipe the script into psql
+  Note: CSV/TSV files have a __key__ column and typed column headers, e.g. age:int, tags:string[] (a JSON array)
+        or body:string:noindex; columns without a type are strings, empty cells are skipped on import
+        (an empty string is written as "" in string columns), keys can be plain IDs or names of -kind,
+        entities without a key get a new ID (with -merge, every row needs a key)
+  Note: NDJSON files (written by export or convert with -format ndjson or a .ndjson or .jsonl extension) have one
+        self-describing JSON object per entity, e.g. {"key":{"path":[{"kind":"K","id":1}]},"properties":{"n":{"int":5}}},
+        and can be read back by import and all other commands in place of export files
+  Note: Parquet files (written by export or convert with -format parquet or a .parquet extension) have one kind each,
+        with a column per property, repeated columns for arrays, groups for embedded entities and a __key__ column
+        plus key component columns; for several kinds, convert writes <out> with the kind inserted before .parquet;
+        export to Parquet goes through a temporary export file
+  Note: -read-time reads all pages of an export at a single snapshot; exports of several kinds
+        with the same -read-time timestamp are consistent with each other
 `)
 	flag.PrintDefaults()
 	os.Exit(1)
@@ -93,8 +198,20 @@ func ensureRequiredArguments() {
 	switch {
 	case *project == "" && cmd != "convert":
 		printUsageAndDie("Missing required option -project\n")
-	case *kind == "" && cmd == "export":
+	case *kind == "" && *keys == "" && *ancestor == "" && *gql == "" && cmd == "export":
 		printUsageAndDie("Missing required option -kind\n")
+	case *gql != "" && (*kind != "" || *filter != "" || *ancestor != "" || *keys != ""):
+		printUsageAndDie("Option -gql can't be combined with -kind, -filter, -ancestor or -keys\n")
+	case *delfields != "" && !*merge:
+		printUsageAndDie("Option -delete-fields requires -merge\n")
+	case *readtime != "" && (cmd != "export" || *keys != ""):
+		printUsageAndDie("Option -read-time is only supported for query exports\n")
+	case (*sincefield != "" || *statefile != "") && (cmd != "export" || *keys != ""):
+		printUsageAndDie("Options -since-field and -state are only supported for query exports\n")
+	case (*sincefield == "") != (*statefile == ""):
+		printUsageAndDie("Options -since-field and -state must be used together\n")
+	case *fields != "" && *keys != "":
+		printUsageAndDie("Option -fields can't be combined with -keys\n")
 	case len(flag.Args()) < 2 && (cmd == "export" || cmd == "import"):
 		printUsageAndDie("Missing required argument <filename>\n")
 	case len(flag.Args()) > 2 && cmd == "export":
@@ -103,44 +220,58 @@ func ensureRequiredArguments() {
 		printUsageAndDie("Missing option -from, -to or -eq for -filter\n")
 	case *filter == "" && *from != "":
 		printUsageAndDie("Missing option -filter for -from\n")
-	case *filter == "" && *to != "":
+	case *filter == "" && *to != "" && cmd != "convert":
 		printUsageAndDie("Missing option -filter for -to\n")
 	}
 }
 
-var filterExprRe = regexp.MustCompile(`\b(\w+)\s*(?:(>=?|<=?|!?=)\s*(\S+))?\b`)
+var fieldNameRe = regexp.MustCompile(`^\s*\w+\s*$`)
 
+// simpleFilter reports whether -filter is just a field name to be used with -from, -to and -eq.
 func simpleFilter(s string) bool {
-	m := filterExprRe.FindAllStringSubmatch(s, -1)
-	if m == nil || len(m) > 1 {
-		return false
-	}
-	return m[0][2] == "" && m[0][3] == ""
+	return fieldNameRe.MatchString(s)
 }
 
-func cmdExport() {
-	ensureRequiredArguments()
-	outfile, err := dsio.OpenForWriting(flag.Args()[1])
-	check(err, flag.Args()[1])
-	defer outfile.Close()
-	ds := connectDS()
-	defer ds.Close()
-	q := datastore.NewQuery(*kind)
+// flagFilter returns the -filter expression, or the -from, -to and -eq conditions on the -filter field.
+func flagFilter(ds *datastore.Client) datastore.EntityFilter {
 	if simpleFilter(*filter) {
-		if *from != "" {
-			q = q.Filter(fmt.Sprintf("%s>=", *filter), *from)
-		}
-		if *to != "" {
-			q = q.Filter(fmt.Sprintf("%s<", *filter), *to)
-		}
-		if *eq != "" {
-			q = q.Filter(fmt.Sprintf("%s=", *filter), *eq)
+		field := strings.TrimSpace(*filter)
+		var filters []datastore.EntityFilter
+		for _, c := range []struct{ op, value string }{{">=", *from}, {"<", *to}, {"=", *eq}} {
+			if c.value == "" {
+				continue
+			}
+			value, err := filterValue(ds, field, c.value)
+			check(err, "filter "+field)
+			filters = append(filters, datastore.PropertyFilter{FieldName: field, Operator: c.op, Value: value})
 		}
-	} else {
-		m := filterExprRe.FindAllStringSubmatch(*filter, -1)
-		for _, flt := range m {
-			q = q.Filter(flt[1]+flt[2], flt[3])
+		if len(filters) == 1 {
+			return filters[0]
 		}
+		return datastore.AndFilter{Filters: filters}
+	}
+	f, err := dsio.ParseFilter(*filter, func(field, literal string) (any, error) {
+		return filterValue(ds, field, literal)
+	})
+	check(err, "-filter")
+	return f
+}
+
+// newQuery returns the query given by -gql, or by -kind, -ancestor and -filter, followed by -order and -limit.
+// It is shared by all commands that run a query, so that they select the same entities.
+func newQuery(ds *datastore.Client) *dsio.Query {
+	q := &dsio.Query{Kind: *kind}
+	if *gql != "" {
+		var err error
+		q, err = dsio.ParseGQL(*gql, bindings)
+		check(err, "-gql")
+	}
+	if key := ancestorKey(); key != nil {
+		q.Ancestor = key
+		q.Namespace = key.Namespace
+	}
+	if *filter != "" {
+		q.Filter = flagFilter(ds)
 	}
 	if *order != "" {
 		if *order == "1" {
@@ -148,14 +279,188 @@ func cmdExport() {
 		} else if *order == "-1" {
 			*order = "-" + *filter
 		}
-		q = q.Order(*order)
+		q.Orders = append(q.Orders, *order)
 	}
 	if *limit != 0 {
-		q = q.Limit(*limit)
+		q.Limit = *limit
+	}
+	q.ReadTime = readTime()
+	log.Printf("Query: %v", q)
+	if !q.ReadTime.IsZero() {
+		log.Printf("Read time: %v", q.ReadTime.Format(time.RFC3339Nano))
+	}
+	return q
+}
+
+// readTime returns the -read-time timestamp, or zero if not given.
+// DataStore requires microsecond precision, so "now" is truncated.
+func readTime() time.Time {
+	switch *readtime {
+	case "":
+		return time.Time{}
+	case "now":
+		return time.Now().UTC().Truncate(time.Microsecond)
+	}
+	t, err := time.Parse(time.RFC3339Nano, *readtime)
+	check(err, "-read-time")
+	return t
+}
+
+// dryRun prints the query and the number of matching entities if -dry-run is given.
+func dryRun(ds *datastore.Client, q *dsio.Query) bool {
+	if !*dryrun {
+		return false
+	}
+	n, err := dsio.CountQuery(ds, q)
+	check(err, "ds.Count")
+	fmt.Printf("%v\n%v matching entities\n", q, n)
+	return true
+}
+
+func cmdExport() {
+	ensureRequiredArguments()
+	if !slices.Contains([]string{"", "ndjson", "parquet"}, *format) {
+		printUsageAndDie("Unsupported -format for export\n")
+	}
+	ds := connectDS()
+	defer ds.Close()
+	if *keys != "" {
+		outfile := createExportFile(flag.Args()[1])
+		defer outfile.Close()
+		missing, err := dsio.ExportKeys(ds, readKeys(*keys), outfile)
+		check(err, "ds.ExportKeys")
+		check(outfile.Close(), flag.Args()[1])
+		reportMissingKeys(missing)
+		return
+	}
+	q := newQuery(ds)
+	var fieldList []string
+	if *fields != "" {
+		if len(q.Projection) > 0 || q.KeysOnly {
+			printUsageAndDie("Option -fields can't be combined with a GQL projection\n")
+		}
+		fieldList = splitList(*fields)
+	}
+	if *sincefield != "" {
+		exportIncremental(ds, q, fieldList)
+		return
+	}
+	if dryRun(ds, q) {
+		return
+	}
+	outfile := createExportFile(flag.Args()[1])
+	defer outfile.Close()
+	err := dsio.ExportQuery(ds, q, fieldList, outfile)
+	check(err, "ds.Export")
+	check(outfile.Close(), flag.Args()[1])
+}
+
+// createExportFile creates the output file of an export, in the format given by -format or its extension.
+func createExportFile(filename string) io.WriteCloser {
+	if parquetOutput(filename) {
+		return newParquetExport(filename)
 	}
-	it := ds.Run(context.Background(), q)
-	err = dsio.Export(it, outfile)
+	outfile, err := dsio.OpenForWriting(filename)
+	check(err, filename)
+	if ndjsonOutput(filename) {
+		outfile = dsio.NewNDJSONWriter(outfile)
+	}
+	return outfile
+}
+
+// ndjsonOutput reports whether a file is to be written in NDJSON format, per -format or its extension.
+func ndjsonOutput(filename string) bool {
+	switch filepath.Ext(strings.TrimSuffix(filename, ".gz")) {
+	case ".ndjson", ".jsonl":
+		return true
+	}
+	return *format == "ndjson"
+}
+
+// parquetOutput reports whether a file is to be written in Parquet format, per -format or its extension.
+func parquetOutput(filename string) bool {
+	return filepath.Ext(strings.TrimSuffix(filename, ".gz")) == ".parquet" || *format == "parquet"
+}
+
+// parquetExport collects an export in a temporary file, which Close converts to Parquet.
+type parquetExport struct {
+	*os.File
+	out  string
+	once sync.Once
+	err  error
+}
+
+func newParquetExport(out string) *parquetExport {
+	tmp, err := os.CreateTemp("", "dsutil-*.ds")
+	check(err, "CreateTemp")
+	removeOnExit = append(removeOnExit, tmp.Name())
+	return &parquetExport{File: tmp, out: out}
+}
+
+func (pe *parquetExport) Close() error {
+	pe.once.Do(func() {
+		defer os.Remove(pe.Name())
+		if pe.err = pe.File.Close(); pe.err == nil {
+			pe.err = writeParquet(pe.Name(), pe.out)
+		}
+	})
+	return pe.err
+}
+
+// exportIncremental exports the entities changed since the -state high-water mark, then updates -state.
+func exportIncremental(ds *datastore.Client, q *dsio.Query, fieldList []string) {
+	st, err := dsio.ReadIncrementalState(*statefile)
+	check(err, "-state")
+	if st == nil {
+		st = &dsio.IncrementalState{Kind: q.Kind, Field: *sincefield}
+	} else if st.Kind != q.Kind || st.Field != *sincefield {
+		log.Fatalf("State file %s is for %s.%s, not %s.%s", *statefile, st.Kind, st.Field, q.Kind, *sincefield)
+	}
+	if st.Since != "" {
+		log.Printf("Exporting %s changed since %s", q.Kind, st.Since)
+	}
+	iq, err := st.Restrict(q)
+	check(err, "-state")
+	if dryRun(ds, iq) {
+		return
+	}
+	outfile := createExportFile(flag.Args()[1])
+	defer outfile.Close()
+	err = dsio.ExportIncremental(ds, q, fieldList, st, outfile)
 	check(err, "ds.Export")
+	check(outfile.Close(), flag.Args()[1])
+	check(dsio.WriteIncrementalState(*statefile, st), "-state")
+	log.Printf("New high-water mark: %s", st.Since)
+}
+
+// ancestorKey returns the parsed -ancestor key, or nil if not given.
+func ancestorKey() *datastore.Key {
+	if *ancestor == "" {
+		return nil
+	}
+	key, err := dsio.ParseAnyKey(*ancestor)
+	check(err, "-ancestor")
+	return key
+}
+
+var kindSchema map[string][]string
+
+// filterValue parses a filter value with an optional type prefix (e.g. "int:30").
+// Without a prefix, the type is inferred from the -kind schema in DataStore metadata.
+func filterValue(ds *datastore.Client, field, s string) (any, error) {
+	if field == "__key__" && !dsio.HasTypePrefix(s) {
+		return dsio.ParseAnyKey(s)
+	}
+	if kindSchema == nil && *kind != "" && !dsio.HasTypePrefix(s) {
+		namespace := ""
+		if key := ancestorKey(); key != nil {
+			namespace = key.Namespace
+		}
+		var err error
+		kindSchema, err = dsio.PropertyRepresentations(ds, namespace, *kind)
+		check(err, "ds.PropertyRepresentations")
+	}
+	return dsio.ParseTypedValue(s, kindSchema[field])
 }
 
 func cmdImport() {
@@ -177,12 +482,39 @@ func importFile(filename string) {
 	defer infile.Close()
 	ds := connectDS()
 	defer ds.Close()
-	err = dsio.Import(infile, ds)
+	if comma := csvComma(filename); comma != 0 {
+		opts := dsio.CSVOptions{Comma: comma, Kind: *kind}
+		if *merge {
+			err = dsio.ImportCSVMerge(infile, ds, opts, splitList(*delfields))
+		} else {
+			err = dsio.ImportCSV(infile, ds, opts)
+		}
+	} else if *merge {
+		err = dsio.ImportMerge(infile, ds, splitList(*delfields))
+	} else {
+		err = dsio.Import(infile, ds)
+	}
 	check(err, "ds.Import")
 }
 
+// splitList splits a comma-separated list, skipping empty elements.
+func splitList(s string) (res []string) {
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			res = append(res, e)
+		}
+	}
+	return
+}
+
+// removeOnExit lists the temporary files to remove when check exits.
+var removeOnExit []string
+
 func check(err error, msg string) {
 	if err != nil {
+		for _, name := range removeOnExit {
+			os.Remove(name)
+		}
 		log.Fatalf("%s: %v", msg, err)
 	}
 }
@@ -194,8 +526,8 @@ func cmdSet() {
 	if len(flag.Args()) != 4 {
 		printUsageAndDie("'set' requires 3 arguments: FieldName, type and Value\n")
 	}
-	if *kind == "" {
-		printUsageAndDie("Missing required option -kind\n")
+	if *kind == "" && *ancestor == "" && *gql == "" {
+		printUsageAndDie("Missing required option -kind, -ancestor or -gql\n")
 	}
 	var err error
 	key, valueType, valueStr := flag.Args()[1], flag.Args()[2], flag.Args()[3]
@@ -212,21 +544,15 @@ func cmdSet() {
 	default:
 		check(errors.New("Invalid type "+valueType), "parse type")
 	}
-	log.Printf("Updating %s, setting %s=%v", *kind, key, value)
-	q := datastore.NewQuery(*kind)
-	if *from != "" {
-		log.Printf("where %s >= %v", *filter, *from)
-		q = q.Filter(fmt.Sprintf("%s>=", *filter), *from)
-	}
-	if *to != "" {
-		log.Printf("where %s < %v", *filter, *to)
-		q = q.Filter(fmt.Sprintf("%s<", *filter), *to)
+	log.Printf("Updating entities, setting %s=%v", key, value)
+	q := newQuery(ds)
+	if len(q.Projection) > 0 || q.KeysOnly {
+		printUsageAndDie("'set' requires a query returning full entities\n")
 	}
-	if *eq != "" {
-		log.Printf("where %s = %v", *filter, *eq)
-		q = q.Filter(fmt.Sprintf("%s=", *filter), *eq)
+	if dryRun(ds, q) {
+		return
 	}
-	it := ds.Run(context.Background(), q)
+	it := ds.Run(context.Background(), q.DatastoreQuery())
 	n := 0
 	for {
 		rec := dsio.Entity{}
@@ -256,10 +582,15 @@ func cmdSet() {
 
 func cmdDelete() {
 	ensureRequiredArguments()
+	queryDelete := *kind != "" || *ancestor != "" || *filter != "" || *gql != ""
+	if (queryDelete || *keys != "") && len(flag.Args()) > 1 || queryDelete && *keys != "" {
+		printUsageAndDie("'delete' supports a query OR -keys OR input file(s), only one of them\n")
+	}
 	ds := connectDS()
 	defer ds.Close()
-	if *filter != "" && len(flag.Args()) > 1 {
-		printUsageAndDie("'delete' supports -filter OR input file(s), not both\n")
+	if *keys != "" {
+		deleteKeys(readKeys(*keys), ds)
+		return
 	}
 	if len(flag.Args()) > 1 {
 		for _, ff := range flag.Args()[1:] {
@@ -271,36 +602,19 @@ func cmdDelete() {
 		}
 		return
 	}
-	if *kind == "" {
-		printUsageAndDie("Missing required option -kind\n")
-	}
-	log.Printf("Deleting entities from %s", *kind)
-	q := datastore.NewQuery(*kind)
-	if *from != "" {
-		log.Printf("where %s >= %v", *filter, *from)
-		q = q.Filter(fmt.Sprintf("%s>=", *filter), *from)
-	}
-	if *to != "" {
-		log.Printf("where %s < %v", *filter, *to)
-		q = q.Filter(fmt.Sprintf("%s<", *filter), *to)
+	if *kind == "" && *ancestor == "" && *gql == "" {
+		printUsageAndDie("Missing required option -kind, -ancestor or -gql\n")
 	}
-	if *eq != "" {
-		log.Printf("where %s = %v", *filter, *eq)
-		q = q.Filter(fmt.Sprintf("%s=", *filter), *eq)
+	log.Printf("Deleting entities")
+	q := newQuery(ds)
+	if len(q.Projection) > 0 || q.Distinct || len(q.DistinctOn) > 0 {
+		printUsageAndDie("'delete' does not support projection queries\n")
 	}
-	if *order != "" {
-		if *order == "1" {
-			*order = *filter
-		} else if *order == "-1" {
-			*order = "-" + *filter
-		}
-		q = q.Order(*order)
-	}
-	if *limit != 0 {
-		q = q.Limit(*limit)
+	q.KeysOnly = true
+	if dryRun(ds, q) {
+		return
 	}
-	q.KeysOnly()
-	it := ds.Run(context.Background(), q)
+	it := ds.Run(context.Background(), q.DatastoreQuery())
 	n := 0
 	var keys []*datastore.Key
 	for {
@@ -325,6 +639,50 @@ func cmdDelete() {
 	log.Printf("Deleted %v", n)
 }
 
+func deleteKeys(keys []*datastore.Key, ds *datastore.Client) {
+	log.Printf("Deleting %v keys", len(keys))
+	var missing []*datastore.Key
+	n := 0
+	batchSize := 200
+	for len(keys) > 0 {
+		batch := keys[:min(batchSize, len(keys))]
+		keys = keys[len(batch):]
+		found, notfound, err := dsio.GetEntities(ds, batch)
+		check(err, "ds.GetMulti")
+		missing = append(missing, notfound...)
+		var existing []*datastore.Key
+		for _, rec := range found {
+			log.Printf("Deleting %v", rec.Key)
+			existing = append(existing, rec.Key)
+		}
+		if len(existing) > 0 {
+			err = ds.DeleteMulti(context.Background(), existing)
+			check(err, "ds.DeleteMulti")
+		}
+		n += len(existing)
+	}
+	log.Printf("Deleted %v", n)
+	reportMissingKeys(missing)
+}
+
+func readKeys(filename string) []*datastore.Key {
+	infile, err := dsio.OpenForReading(filename)
+	check(err, filename)
+	defer infile.Close()
+	res, err := dsio.ReadKeys(infile)
+	check(err, filename)
+	return res
+}
+
+func reportMissingKeys(missing []*datastore.Key) {
+	for _, key := range missing {
+		log.Printf("Key not found: %s", dsio.MarshalKey(key))
+	}
+	if len(missing) > 0 {
+		log.Printf("%v key(s) not found", len(missing))
+	}
+}
+
 func deleteFromFile(filename string, ds *datastore.Client) {
 	infile, err := dsio.OpenForReading(filename)
 	check(err, filename)
@@ -359,11 +717,238 @@ func deleteFromFile(filename string, ds *datastore.Client) {
 	check(err, "ds.Delete")
 }
 
+func cmdDiff() {
+	if len(flag.Args()) != 3 {
+		printUsageAndDie("diff arguments should be <a> <b>\n")
+	}
+	if !slices.Contains([]string{"", "text", "json", "ds", "ndjson"}, *format) {
+		printUsageAndDie("Unsupported -format for diff\n")
+	}
+	a, err := dsio.OpenForReading(flag.Args()[1])
+	check(err, flag.Args()[1])
+	defer a.Close()
+	b, err := dsio.OpenForReading(flag.Args()[2])
+	check(err, flag.Args()[2])
+	defer b.Close()
+	var out io.WriteCloser = nopCloser{os.Stdout}
+	if *format == "ndjson" {
+		out = dsio.NewNDJSONWriter(out)
+	}
+	wbuf := bufio.NewWriter(out)
+	enc := dsio.NewEncoder(wbuf)
+	if *format == "ds" || *format == "ndjson" {
+		check(enc.WriteMetadata(&dsio.Metadata{Patch: true}), "Diff")
+	}
+	jenc := json.NewEncoder(wbuf)
+	counts := make(map[string]int)
+	err = dsio.Diff(a, b, func(c *dsio.Change) error {
+		counts[c.Op]++
+		switch *format {
+		case "json":
+			return jenc.Encode(c)
+		case "ds", "ndjson":
+			return enc.EncodeEntity(dsio.PatchEntity(c))
+		}
+		_, err := wbuf.WriteString(dsio.FormatChange(c))
+		return err
+	})
+	check(err, "Diff")
+	check(enc.Flush(), "Diff")
+	check(wbuf.Flush(), "Diff")
+	check(out.Close(), "Diff")
+	log.Printf("%d added, %d removed, %d modified", counts[dsio.ChangeAdd], counts[dsio.ChangeRemove], counts[dsio.ChangeModify])
+	if len(counts) > 0 {
+		os.Exit(1)
+	}
+}
+
+func cmdApply() {
+	ensureRequiredArguments()
+	if len(flag.Args()) != 2 {
+		printUsageAndDie("apply arguments should be <patch>\n")
+	}
+	in, err := dsio.OpenForReading(flag.Args()[1])
+	check(err, flag.Args()[1])
+	defer in.Close()
+	ds := connectDS()
+	defer ds.Close()
+	res, err := dsio.ApplyPatch(ds, in, *dryrun)
+	if res != nil {
+		for _, c := range res.Conflicts {
+			fmt.Printf("Conflict %s: %s\n", dsio.MarshalKey(c.Key), c.Reason)
+		}
+	}
+	check(err, "ApplyPatch")
+	verb := "Applied"
+	if *dryrun {
+		verb = "Would apply"
+	}
+	log.Printf("%s %d changes, %d unchanged, %d conflicts", verb, res.Applied, res.Unchanged, len(res.Conflicts))
+	if len(res.Conflicts) > 0 {
+		os.Exit(1)
+	}
+}
+
+func cmdVerify() {
+	ensureRequiredArguments()
+	if len(flag.Args()) < 2 {
+		printUsageAndDie("Missing required argument <filename>\n")
+	}
+	ds := connectDS()
+	defer ds.Close()
+	total, missing, different := 0, 0, 0
+	for _, ff := range flag.Args()[1:] {
+		filenames, err := filepath.Glob(ff)
+		check(err, "Glob")
+		for _, f := range filenames {
+			log.Printf("Verifying file %s", f)
+			in, err := dsio.OpenForReading(f)
+			check(err, f)
+			n, err := dsio.Verify(ds, in, func(m *dsio.Mismatch) error {
+				if m.Missing {
+					missing++
+				} else {
+					different++
+				}
+				fmt.Print(m)
+				return nil
+			})
+			in.Close()
+			check(err, "Verify")
+			total += n
+		}
+	}
+	log.Printf("Verified %d entities: %d missing, %d different", total, missing, different)
+	if missing+different > 0 {
+		os.Exit(1)
+	}
+}
+
+func cmdStats() {
+	if len(flag.Args()) != 2 {
+		printUsageAndDie("stats arguments should be <filename>\n")
+	}
+	in, err := dsio.OpenForReading(flag.Args()[1])
+	check(err, flag.Args()[1])
+	defer in.Close()
+	stats, err := dsio.ReadStats(in)
+	check(err, "ReadStats")
+	switch *format {
+	case "", "text":
+		err = stats.WriteReport(os.Stdout)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(stats)
+	default:
+		printUsageAndDie("Unsupported -format for stats\n")
+	}
+	check(err, "stats")
+}
+
+func cmdSchema(cmd string) {
+	if len(flag.Args()) != 2 && (cmd == "schema" || len(flag.Args()) != 3) {
+		printUsageAndDie(cmd + " arguments should be <filename>\n")
+	}
+	in, err := dsio.OpenForReading(flag.Args()[1])
+	check(err, flag.Args()[1])
+	defer in.Close()
+	kinds, err := dsio.ReadSchema(in)
+	check(err, "ReadSchema")
+	if cmd == "gen-go" {
+		out := os.Stdout
+		if len(flag.Args()) == 3 {
+			out, err = os.Create(flag.Args()[2])
+			check(err, flag.Args()[2])
+			defer out.Close()
+		}
+		check(dsio.GenerateGo(out, *pkgname, kinds), "gen-go")
+		return
+	}
+	switch *format {
+	case "", "text":
+		err = dsio.WriteSchema(os.Stdout, kinds)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(kinds)
+	default:
+		printUsageAndDie("Unsupported -format for schema\n")
+	}
+	check(err, "schema")
+}
+
+func cmdMergeIncremental() {
+	if len(flag.Args()) < 4 {
+		printUsageAndDie("merge-incremental arguments should be <base> <inc>... <out>\n")
+	}
+	args := flag.Args()[1:]
+	base, err := dsio.OpenForReading(args[0])
+	check(err, args[0])
+	defer base.Close()
+	var incs []io.Reader
+	for _, f := range args[1 : len(args)-1] {
+		inc, err := dsio.OpenForReading(f)
+		check(err, f)
+		defer inc.Close()
+		incs = append(incs, inc)
+	}
+	outname := args[len(args)-1]
+	out, err := dsio.OpenForWriting(outname)
+	check(err, outname)
+	err = dsio.MergeIncremental(base, incs, out)
+	check(err, "MergeIncremental")
+	check(out.Close(), outname)
+}
+
 func cmdConvert() {
 	ensureRequiredArguments()
 	if len(flag.Args()) != 3 {
 		printUsageAndDie("convert arguments should be <in> <out>\n")
 	}
+	if !slices.Contains([]string{"", "ndjson", "parquet"}, *format) {
+		printUsageAndDie("Unsupported -format for convert\n")
+	}
+	target := *to
+	if *format != "" {
+		if target != "" && target != *format {
+			printUsageAndDie("Options -to and -format disagree for convert\n")
+		}
+		target = *format
+	}
+	if target == "" && csvComma(flag.Args()[2]) != 0 {
+		target = "csv"
+	} else if target == "" && parquetOutput(flag.Args()[2]) {
+		target = "parquet"
+	} else if target == "" && ndjsonOutput(flag.Args()[2]) {
+		target = "ndjson"
+	}
+	switch target {
+	case "", "go":
+	case "ndjson":
+		convertNDJSON()
+		return
+	case "parquet":
+		check(writeParquet(flag.Args()[1], flag.Args()[2]), "convert")
+		return
+	case "postgres":
+		opts := dsio.PostgresOptions{Arrays: *pgarrays, Nested: *pgnested, Keys: *pgkeys}
+		convertWithSchema(func(w io.Writer, kinds []*dsio.KindSchema, r io.Reader) error {
+			return dsio.WritePostgres(w, kinds, r, opts)
+		})
+		return
+	case "csv", "tsv":
+		opts := dsio.CSVOptions{Comma: csvComma(flag.Args()[2])}
+		if target == "tsv" {
+			opts.Comma = '\t'
+		}
+		convertWithSchema(func(w io.Writer, kinds []*dsio.KindSchema, r io.Reader) error {
+			return dsio.WriteCSV(w, kinds, r, opts)
+		})
+		return
+	default:
+		printUsageAndDie("Unsupported -to format for convert\n")
+	}
 	in, err := dsio.OpenForReading(flag.Args()[1])
 	check(err, flag.Args()[1])
 	defer in.Close()
@@ -422,6 +1007,118 @@ outer:
 	check(<-werrCh, "write")
 }
 
+// convertNDJSON converts an export file to NDJSON.
+func convertNDJSON() {
+	in, err := dsio.OpenForReading(flag.Args()[1])
+	check(err, flag.Args()[1])
+	defer in.Close()
+	var out io.WriteCloser = os.Stdout
+	if flag.Args()[2] != "-" {
+		out, err = dsio.OpenForWriting(flag.Args()[2])
+		check(err, flag.Args()[2])
+	}
+	check(dsio.WriteNDJSON(out, in), "convert")
+	check(out.Close(), flag.Args()[2])
+}
+
+// writeParquet converts an export file to Parquet, one file per kind (reading the file once per kind).
+// With several kinds, the kind is inserted into the output file name, e.g. out.User.parquet.
+func writeParquet(in, out string) error {
+	infile, err := dsio.OpenForReading(in)
+	if err != nil {
+		return err
+	}
+	kinds, err := dsio.ReadSchema(infile)
+	infile.Close()
+	if err != nil {
+		return err
+	}
+	if len(kinds) == 0 && *kind != "" {
+		kinds = []*dsio.KindSchema{{Kind: *kind}} // an empty export
+	}
+	for _, ks := range kinds {
+		outname := out
+		if len(kinds) > 1 {
+			base, gz := strings.CutSuffix(out, ".gz")
+			ext := filepath.Ext(base)
+			outname = strings.TrimSuffix(base, ext) + "." + ks.Kind + ext
+			if gz {
+				outname += ".gz"
+			}
+		}
+		log.Printf("Writing %s to %s", ks.Kind, outname)
+		if err = writeParquetKind(in, outname, ks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeParquetKind(in, out string, ks *dsio.KindSchema) error {
+	infile, err := dsio.OpenForReading(in)
+	if err != nil {
+		return err
+	}
+	defer infile.Close()
+	outfile, err := dsio.OpenForWriting(out)
+	if err != nil {
+		return err
+	}
+	if err = dsio.WriteParquet(outfile, ks, infile, dsio.ParquetOptions{RowGroupSize: *rowgroup}); err != nil {
+		outfile.Close()
+		return err
+	}
+	return outfile.Close()
+}
+
+// convertWithSchema infers the schema of the input file, then converts the file with it (reading the file twice).
+func convertWithSchema(write func(w io.Writer, kinds []*dsio.KindSchema, r io.Reader) error) {
+	in, err := dsio.OpenForReading(flag.Args()[1])
+	check(err, flag.Args()[1])
+	kinds, err := dsio.ReadSchema(in)
+	in.Close()
+	check(err, "ReadSchema")
+	in, err = dsio.OpenForReading(flag.Args()[1])
+	check(err, flag.Args()[1])
+	defer in.Close()
+	var out io.WriteCloser = nopCloser{os.Stdout}
+	if flag.Args()[2] != "-" {
+		out, err = dsio.OpenForWriting(flag.Args()[2])
+		check(err, flag.Args()[2])
+	}
+	check(write(out, kinds, in), "convert")
+	check(out.Close(), flag.Args()[2])
+}
+
+// nopCloser is a WriteCloser not closing the underlying writer, e.g. os.Stdout.
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// csvComma returns the field delimiter of a .csv or .tsv file (optionally gzipped), or 0 for other files.
+func csvComma(filename string) rune {
+	switch filepath.Ext(strings.TrimSuffix(filename, ".gz")) {
+	case ".csv":
+		return ','
+	case ".tsv":
+		return '\t'
+	}
+	return 0
+}
+
+func cmdKey() {
+	if len(flag.Args()) < 2 {
+		printUsageAndDie("Missing required argument <key>\n")
+	}
+	for _, s := range flag.Args()[1:] {
+		key, err := dsio.ParseAnyKey(s)
+		check(err, "parse key")
+		fmt.Printf("%s\n  %s\n  %s\n", dsio.MarshalKey(key), key.Encode(), dsio.FormatGQLKey(key))
+	}
+}
+
 func cmdTest() {
 	ensureRequiredArguments()
 	ds := connectDS()